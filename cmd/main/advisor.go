@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Catching up is spread over as few days as possible without limiting any day to less than
+// this share of the daily average
+const minCatchUpShare = 0.5
+
+// What to aim for per day, and what to do to get there
+type budgetAdvice struct {
+	target  float64 // GB/day that keeps the rest of the cycle under the cap
+	pace    float64 // GB/day used so far this cycle
+	actions []string
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// Works out the daily target and actions from the per day remaining and how far ahead of or
+// behind the allowed pace usage is (the differential, negative when behind)
+func adviseBudget(gbPerDayLeft, differential, gbPerDay, daysLeft, daysSoFar, used, capGB float64) budgetAdvice {
+	if daysSoFar < 1 {
+		daysSoFar = 1
+	}
+	advice := budgetAdvice{target: gbPerDayLeft, pace: used / daysSoFar}
+	wholeDays := int(math.Ceil(daysLeft))
+	if wholeDays < 1 {
+		wholeDays = 1
+	}
+
+	switch {
+	case used >= capGB:
+		advice.target = 0
+		advice.actions = append(advice.actions, "The cap has been reached, anything more counts as overage",
+			fmt.Sprintf("Hold off on large downloads and updates until the cycle ends in %s", plural(wholeDays, "day")))
+	case differential >= 0:
+		advice.actions = append(advice.actions, fmt.Sprintf("On pace with %.1f GB to spare, up to %.1f GB/day keeps you under the cap",
+			differential, gbPerDayLeft))
+	default:
+		// make up the shortfall as quickly as is bearable, then go back to the daily average
+		deficit := -differential
+		days, limit := wholeDays, gbPerDayLeft
+		for n := 1; n < wholeDays; n++ {
+			if catchUp := gbPerDay - deficit/float64(n); catchUp >= gbPerDay*minCatchUpShare {
+				days, limit = n, catchUp
+				break
+			}
+		}
+		advice.actions = append(advice.actions, fmt.Sprintf("Limit to %.0f GB/day for the next %s to get back on pace", limit, plural(days, "day")))
+		if days < wholeDays {
+			advice.actions = append(advice.actions, fmt.Sprintf("Then up to %.0f GB/day for the remaining %s", gbPerDay, plural(wholeDays-days, "day")))
+		}
+		if over := used + advice.pace*daysLeft - capGB; over > 0 {
+			advice.actions = append(advice.actions, fmt.Sprintf("At the current pace of %.1f GB/day you would go %.0f GB over the cap", advice.pace, over))
+		}
+	}
+
+	return advice
+}
+
+// Builds the advice lines shown with the calculation results
+func formatAdvice(advice budgetAdvice) string {
+	output := fmt.Sprintf("Daily target:                   %.2f GB    (Pace so far: %.2f GB/day)\r\n", advice.target, advice.pace)
+	for _, action := range advice.actions {
+		output += "  - " + action + "\r\n"
+	}
+
+	return output
+}
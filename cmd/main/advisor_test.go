@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAdviseBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		perDayLeft   float64
+		differential float64
+		daysLeft     float64
+		daysSoFar    float64
+		used         float64
+		expected     []string
+	}{
+		{"Check on pace", 42.5, 30, 20, 10, 380, []string{"On pace with 30.0 GB to spare, up to 42.5 GB/day keeps you under the cap"}},
+		{"Check small shortfall is made up quickly", 39.7, -20, 20, 10, 430, []string{
+			"Limit to 21 GB/day for the next 1 day to get back on pace",
+			"Then up to 41 GB/day for the remaining 19 days",
+			"At the current pace of 43.0 GB/day you would go 61 GB over the cap"}},
+		{"Check bigger shortfall is spread over days", 35.5, -60, 20, 10, 470, []string{
+			"Limit to 21 GB/day for the next 3 days to get back on pace",
+			"Then up to 41 GB/day for the remaining 17 days",
+			"At the current pace of 47.0 GB/day you would go 181 GB over the cap"}},
+		{"Check huge shortfall uses the rest of the cycle", 5, -300, 3, 27, 1214, []string{
+			"Limit to 5 GB/day for the next 3 days to get back on pace",
+			"At the current pace of 45.0 GB/day you would go 120 GB over the cap"}},
+		{"Check cap reached", 0, -100, 5.5, 24.5, 1229, []string{
+			"The cap has been reached, anything more counts as overage",
+			"Hold off on large downloads and updates until the cycle ends in 6 days"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			advice := adviseBudget(test.perDayLeft, test.differential, 1229.0/30, test.daysLeft, test.daysSoFar, test.used, 1229)
+			if !reflect.DeepEqual(advice.actions, test.expected) {
+				t.Errorf("ERROR: Expected: %q got: %q", test.expected, advice.actions)
+			}
+		})
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+	"go.uber.org/zap"
+)
+
+// Every change to the stored values is recorded under the profile as audit/<id>, in the same
+// transaction where etcd allows. The id is when the change was made followed by the host, so
+// entries sort oldest first. Each entry holds the old and new value of every key it changed,
+// which is enough to undo it later
+
+const (
+	auditSave        = "save"
+	auditDeviceUsage = "device usage"
+	auditDeleteDay   = "delete day"
+	auditRollover    = "rollover"
+	auditImport      = "import"
+	auditSyncJournal = "sync journal"
+	auditEnforce     = "enforce"
+	auditUndo        = "undo"
+
+	auditKeepMonths       = 12 // entries older than this are dropped at rollover
+	auditChangesShown     = 10 // changes listed per entry, the rest are counted
+	auditIDTimeFormat     = "20060102T150405.000000000Z"
+	auditTimeFormat       = "2006-01-02 15:04:05"
+	auditValueMissingText = "(none)"
+)
+
+var (
+	errUndoConflict  = errors.New("changed again since, undoing would overwrite")
+	errUndoNotAtomic = errors.New("too many changes to undo in one transaction, failing part way would leave some undone")
+)
+
+// Returns true if the undo was refused for something the user can choose to go ahead with
+func undoNeedsForce(err error) bool {
+	return errors.Is(err, errUndoConflict) || errors.Is(err, errUndoNotAtomic)
+}
+
+// One key a change touched, Old is nil if the key didn't exist and New is nil if it was deleted
+type auditChange struct {
+	Key string  `json:"key"`
+	Old *string `json:"old,omitempty"`
+	New *string `json:"new,omitempty"`
+}
+
+// Who changed what, when and where
+type auditEntry struct {
+	ID      string        `json:"id"`
+	Time    time.Time     `json:"time"`
+	User    string        `json:"user"`
+	Host    string        `json:"host"`
+	Op      string        `json:"op"`
+	Changes []auditChange `json:"changes"`
+}
+
+func stringRef(s string) *string {
+	return &s
+}
+
+// Returns the id of an entry made at that time on the host
+func auditID(at time.Time, host string) string {
+	return at.UTC().Format(auditIDTimeFormat) + "-" + keySlug(host)
+}
+
+// Works out what the puts, key deletes and prefix deletes change in the current values, leaving
+// out anything written with the value it already had
+func auditChanges(current map[string][]byte, puts map[string]string, deletes, deletePrefixes []string) []auditChange {
+	changes := []auditChange{}
+	for _, k := range sortedStringKeys(puts) {
+		change := auditChange{Key: k, New: stringRef(puts[k])}
+		if old, ok := current[k]; ok {
+			if string(old) == puts[k] {
+				continue
+			}
+			change.Old = stringRef(string(old))
+		}
+		changes = append(changes, change)
+	}
+
+	isDeleted := func(k string) bool {
+		for _, key := range deletes {
+			if k == key {
+				return true
+			}
+		}
+		for _, prefix := range deletePrefixes {
+			if strings.HasPrefix(k, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	deleted := []string{}
+	for k := range current {
+		if _, put := puts[k]; !put && isDeleted(k) {
+			deleted = append(deleted, k)
+		}
+	}
+	sort.Strings(deleted)
+	for _, k := range deleted {
+		changes = append(changes, auditChange{Key: k, Old: stringRef(string(current[k]))})
+	}
+
+	return changes
+}
+
+// Returns the entries stored under the prefix, newest first. Anything that isn't an entry is
+// skipped
+func parseAuditEntries(values map[string][]byte, prefix string) []auditEntry {
+	entries := []auditEntry{}
+	for k, v := range values {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(v, &entry); err == nil && entry.ID != "" {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+
+	return entries
+}
+
+// Returns what to write and which keys to delete to put back what the entry changed
+func undoChanges(entry auditEntry) (map[string]string, []string) {
+	puts := map[string]string{}
+	deletes := []string{}
+	for _, change := range entry.Changes {
+		if change.Old != nil {
+			puts[change.Key] = *change.Old
+		} else {
+			deletes = append(deletes, change.Key)
+		}
+	}
+
+	return puts, deletes
+}
+
+// Returns the keys the entry changed that have been changed again since, which undoing it
+// would overwrite
+func undoConflicts(entry auditEntry, current map[string][]byte) []string {
+	conflicts := []string{}
+	for _, change := range entry.Changes {
+		value, exists := current[change.Key]
+		if (change.New == nil && exists) || (change.New != nil && (!exists || string(value) != *change.New)) {
+			conflicts = append(conflicts, change.Key)
+		}
+	}
+
+	return conflicts
+}
+
+func auditValueText(value *string) string {
+	if value == nil {
+		return auditValueMissingText
+	}
+
+	return *value
+}
+
+// Builds the listing of the entries, with up to maxChanges changes each (all if 0). The trim
+// prefix is taken off the keys to keep them short
+func formatAuditEntries(entries []auditEntry, trim string, maxChanges int) string {
+	output := ""
+	for _, entry := range entries {
+		output += fmt.Sprintf("%s  %s  %s@%s  %s (%s)\r\n", entry.ID, entry.Time.Local().Format(auditTimeFormat),
+			entry.User, entry.Host, entry.Op, plural(len(entry.Changes), "change"))
+		for i, change := range entry.Changes {
+			if maxChanges > 0 && i == maxChanges {
+				output += fmt.Sprintf("    ... and %d more\r\n", len(entry.Changes)-maxChanges)
+				break
+			}
+			output += fmt.Sprintf("    %s: %s -> %s\r\n", strings.TrimPrefix(change.Key, trim),
+				auditValueText(change.Old), auditValueText(change.New))
+		}
+	}
+
+	return output
+}
+
+// Returns who is making changes and on which host
+func auditIdentity() (string, string) {
+	name := os.Getenv("USERNAME")
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, _ := os.Hostname()
+
+	return name, host
+}
+
+// Returns where the entries of the profile in use are kept in the store in use. In etcd that is
+// beside the profile's values rather than under them, so they aren't read with every redraw
+func (mw *MainWin) auditPrefix() string {
+	if mw.useEtcd {
+		return mw.config.Etcd.BaseKeyToWrite + "-" + regValue15 + "/"
+	}
+
+	return mw.regName(regValue15 + "/")
+}
+
+// Entries used to be kept under audit/ with the profile's values. Moves any still there out of
+// the values just read and into their own prefix
+func (mw *MainWin) moveOldAuditEntries() error {
+	oldPrefix := mw.config.Etcd.BaseKeyToWrite + "/" + regValue15 + "/"
+	moved := map[string]string{}
+	for k, v := range mw.config.dbValues {
+		if strings.HasPrefix(k, oldPrefix) {
+			moved[mw.auditPrefix()+k[len(oldPrefix):]] = string(v)
+			delete(mw.config.dbValues, k)
+			delete(mw.config.dbRevisions, k)
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	if _, err := mw.etcd.commitBatches(moved); err != nil {
+		return &storeError{Store: "etcd", Op: "move audit", Key: oldPrefix, Err: err}
+	}
+	if err := mw.etcd.commit(nil, oldPrefix); err != nil {
+		return &storeError{Store: "etcd", Op: "move audit", Key: oldPrefix, Err: err}
+	}
+	zap.L().Info("moved audit entries out of the values", zap.Int("entries", len(moved)))
+
+	return nil
+}
+
+// Returns the key and value to store an entry for the changes at, false if nothing changed
+func (mw *MainWin) auditPut(op string, changes []auditChange) (string, string, bool) {
+	if len(changes) == 0 {
+		return "", "", false
+	}
+	name, host := auditIdentity()
+	now := time.Now()
+	entry := auditEntry{ID: auditID(now, host), Time: now, User: name, Host: host, Op: op, Changes: changes}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return "", "", false
+	}
+
+	return mw.auditPrefix() + entry.ID, string(value), true
+}
+
+// Returns the puts with an entry added recording what they and the key deletes change in the
+// current values
+func (mw *MainWin) withAudit(op string, current map[string][]byte, puts map[string]string, deletes ...string) map[string]string {
+	key, value, ok := mw.auditPut(op, auditChanges(current, puts, deletes, nil))
+	if !ok {
+		return puts
+	}
+	audited := map[string]string{key: value}
+	for k, v := range puts {
+		audited[k] = v
+	}
+
+	return audited
+}
+
+// Writes the puts in as few transactions as etcd allows, each batch along with an entry
+// recording what it changes, so whatever was written before an error can still be undone.
+// Returns how many were written
+func (mw *MainWin) commitAuditedBatches(op string, current map[string][]byte, puts map[string]string) (int, error) {
+	written := 0
+	batch := map[string]string{}
+	for _, k := range sortedStringKeys(puts) {
+		batch[k] = puts[k]
+		if len(batch) == etcdMaxTxnOps-1 || len(batch)+written == len(puts) { // one op is left for the entry
+			if err := mw.etcd.commit(mw.withAudit(op, current, batch)); err != nil {
+				return written, err
+			}
+			written += len(batch)
+			batch = map[string]string{}
+		}
+	}
+
+	return written, nil
+}
+
+// Records an entry for changes that were too many to write in one transaction with it
+func (mw *MainWin) recordAudit(op string, current map[string][]byte, puts map[string]string, deletes ...string) error {
+	key, value, ok := mw.auditPut(op, auditChanges(current, puts, deletes, nil))
+	if !ok {
+		return nil
+	}
+	if err := mw.etcd.write(key, value); err != nil {
+		return &storeError{Store: "etcd", Op: "write", Key: key, Err: err}
+	}
+
+	return nil
+}
+
+// Reads the registry values, a value that was never written is left out
+func (mw *MainWin) readRegValues(names []string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	for _, name := range names {
+		value, err := mw.GetRegStringValue(name)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			values[name] = []byte(value)
+		}
+	}
+
+	return values, nil
+}
+
+// Writes and deletes registry values, recording what that changes. The registry has no
+// transactions, so the entry goes first and lists everything that was about to change
+func (mw *MainWin) setRegValues(op string, values map[string]string, deletes ...string) error {
+	current, err := mw.readRegValues(append(sortedStringKeys(values), deletes...))
+	if err != nil {
+		return err
+	}
+	if key, value, ok := mw.auditPut(op, auditChanges(current, values, deletes, nil)); ok {
+		if err = mw.setSingleRegKeyValue(key, value); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedStringKeys(values) {
+		if err = mw.setSingleRegKeyValue(name, values[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range deletes {
+		if _, exists := current[name]; !exists {
+			continue
+		}
+		if err = mw.key.DeleteValue(name); err != nil {
+			return &storeError{Store: "registry", Op: "delete", Key: name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Reads the audit entries of the profile in use from whichever store is in use, newest first
+func (mw *MainWin) readAuditEntries() ([]auditEntry, error) {
+	prefix := mw.auditPrefix()
+	if mw.useEtcd {
+		values, _, err := mw.etcd.read(prefix)
+		if err != nil {
+			return nil, &storeError{Store: "etcd", Op: "read", Key: prefix, Err: err}
+		}
+		return parseAuditEntries(values, prefix), nil
+	}
+
+	if mw.key == nil {
+		return nil, &storeError{Store: "registry", Op: "read", Key: prefix, Err: errors.New("registry key is not open")}
+	}
+	names, err := mw.key.ReadValueNames(0)
+	if err != nil {
+		return nil, &storeError{Store: "registry", Op: "read", Key: prefix, Err: err}
+	}
+	auditNames := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			auditNames = append(auditNames, name)
+		}
+	}
+	values, err := mw.readRegValues(auditNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAuditEntries(values, prefix), nil
+}
+
+// Puts back what the entry with the id changed, recording that as a change of its own. Keys
+// changed again since are only overwritten if forced, and changes too big for one etcd
+// transaction are only undone in several if forced
+func (mw *MainWin) undoAudit(id string, force bool) error {
+	entries, err := mw.readAuditEntries()
+	if err != nil {
+		return err
+	}
+	index := sort.Search(len(entries), func(i int) bool { return entries[i].ID <= id })
+	if index == len(entries) || entries[index].ID != id {
+		return fmt.Errorf("no change %q in the audit trail", id)
+	}
+	entry := entries[index]
+	puts, deletes := undoChanges(entry)
+	op := auditUndo + " " + id
+
+	var current map[string][]byte
+	var revisions map[string]int64
+	if mw.useEtcd {
+		current, revisions, err = mw.etcd.read(mw.config.Etcd.BaseKeyToWrite + "/")
+		if err != nil {
+			return &storeError{Store: "etcd", Op: "read", Key: mw.config.Etcd.BaseKeyToWrite, Err: err}
+		}
+	} else {
+		keys := []string{}
+		for _, change := range entry.Changes {
+			keys = append(keys, change.Key)
+		}
+		if current, err = mw.readRegValues(keys); err != nil {
+			return err
+		}
+	}
+	refused := []error{}
+	if conflicts := undoConflicts(entry, current); len(conflicts) > 0 {
+		for i := range conflicts {
+			conflicts[i] = strings.TrimPrefix(conflicts[i], mw.config.Etcd.BaseKeyToWrite+"/")
+		}
+		refused = append(refused, fmt.Errorf("%w: %s", errUndoConflict, strings.Join(conflicts, ", ")))
+	}
+	atomic := !mw.useEtcd || len(puts)+len(deletes) < etcdMaxTxnOps // one op is left for the entry
+	if !atomic {
+		refused = append(refused, errUndoNotAtomic)
+	}
+	if len(refused) > 0 && !force {
+		return errors.Join(refused...)
+	}
+	zap.L().Info("undoing change", zap.String("id", id), zap.String("op", entry.Op), zap.Int("changes", len(entry.Changes)))
+
+	if !mw.useEtcd {
+		return mw.setRegValues(op, puts, deletes...)
+	}
+
+	if atomic {
+		expected := map[string]int64{}
+		for _, change := range entry.Changes {
+			expected[change.Key] = revisions[change.Key]
+		}
+		ok, err := mw.etcd.commitIf(mw.withAudit(op, current, puts, deletes...), expected, deletes)
+		if err == nil && !ok {
+			return errors.New("another client changed the same values, try again")
+		}
+		return err
+	}
+	// too many for one transaction, so it can't be all or nothing. The entry is only recorded
+	// once everything is back, a partial undo can simply be forced again
+	written, err := mw.etcd.commitBatches(puts)
+	for start := 0; start < len(deletes) && err == nil; start += etcdMaxTxnOps {
+		end := start + etcdMaxTxnOps
+		if end > len(deletes) {
+			end = len(deletes)
+		}
+		if _, err = mw.etcd.commitIf(nil, nil, deletes[start:end]); err == nil {
+			written += end - start
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("undo stopped part way, %d of %d values put back: %w", written, len(puts)+len(deletes), err)
+	}
+
+	return mw.recordAudit(op, current, puts, deletes...)
+}
+
+// Returns the names of the entries stored under the prefix that were made before the cutoff
+func expiredAuditNames(names []string, prefix string, cutoff time.Time) []string {
+	expired := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && name[len(prefix):] < cutoff.UTC().Format(auditIDTimeFormat) {
+			expired = append(expired, name)
+		}
+	}
+
+	return expired
+}
+
+// Drops the entries from before the cutoff from whichever store is in use. In etcd that is one
+// range delete since the ids sort by time
+func (mw *MainWin) pruneAudit(cutoff time.Time) {
+	prefix := mw.auditPrefix()
+	if mw.useEtcd {
+		if err := mw.etcd.deleteRange(prefix, prefix+cutoff.UTC().Format(auditIDTimeFormat)); err != nil {
+			zap.L().Warn("old audit entries not dropped", zap.Error(err))
+		}
+		return
+	}
+
+	if mw.key == nil {
+		return
+	}
+	names, err := mw.key.ReadValueNames(0)
+	if err != nil {
+		zap.L().Warn("old audit entries not dropped", zap.Error(err))
+		return
+	}
+	for _, name := range expiredAuditNames(names, prefix, cutoff) {
+		if err = mw.key.DeleteValue(name); err != nil {
+			zap.L().Warn("old audit entry not dropped", zap.String("name", name), zap.Error(err))
+			return
+		}
+	}
+}
+
+// Opens a window listing the changes made to the profile in use, any of which can be undone
+func (mw *MainWin) showAuditDialog() {
+	var dlg *walk.Dialog
+	var entryList *walk.ListBox
+	var changesBox *walk.TextEdit
+	var entries []auditEntry
+
+	refresh := func() {
+		var err error
+		if entries, err = mw.readAuditEntries(); err != nil {
+			mw.showError("Could not read the audit trail", err)
+		}
+		items := []string{}
+		for _, entry := range entries {
+			items = append(items, fmt.Sprintf("%s  %s@%s  %s (%s)", entry.Time.Local().Format(auditTimeFormat),
+				entry.User, entry.Host, entry.Op, plural(len(entry.Changes), "change")))
+		}
+		entryList.SetModel(items)
+		changesBox.SetText("")
+	}
+	undo := func() {
+		index := entryList.CurrentIndex()
+		if index < 0 || index >= len(entries) {
+			return
+		}
+		err := mw.undoAudit(entries[index].ID, false)
+		if undoNeedsForce(err) &&
+			walk.MsgBox(dlg, "Undo", err.Error()+"\r\n\r\nUndo anyway?", walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) == walk.DlgCmdYes {
+			err = mw.undoAudit(entries[index].ID, true)
+		}
+		if err != nil && !undoNeedsForce(err) {
+			mw.showError("Change not undone", err)
+		}
+		refresh()
+		mw.redrawChart()
+		mw.showReadings()
+		mw.showResults()
+	}
+
+	Dialog{
+		AssignTo: &dlg,
+		Title:    "Audit trail",
+		MinSize:  Size{initialWinWidth, initialWinHeight - 300},
+		Layout:   VBox{},
+		Children: []Widget{
+			ListBox{
+				AssignTo: &entryList,
+				MinSize:  Size{initialWinWidth - 50, 250},
+				OnCurrentIndexChanged: func() {
+					if index := entryList.CurrentIndex(); index >= 0 && index < len(entries) {
+						changesBox.SetText(formatAuditEntries(entries[index:index+1], mw.config.Etcd.BaseKeyToWrite+"/", 0))
+					}
+				},
+			},
+			TextEdit{
+				AssignTo: &changesBox,
+				MinSize:  Size{initialWinWidth - 50, 250},
+				ReadOnly: true,
+				VScroll:  true,
+				Font: Font{
+					Family:    "Courier New",
+					PointSize: 10,
+				},
+			},
+			Composite{
+				Layout: HBox{
+					MarginsZero: true,
+				},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						Text: "   Undo this change   ",
+						OnClicked: func() {
+							undo()
+						},
+					},
+					PushButton{
+						Text: "   Close   ",
+						OnClicked: func() {
+							dlg.Accept()
+						},
+					},
+				},
+			},
+		},
+	}.Create(mw)
+
+	refresh()
+	dlg.Run()
+}
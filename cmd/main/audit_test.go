@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditChanges(t *testing.T) {
+	current := map[string][]byte{
+		"/bw/bwCurrentUsed":   []byte("500"),
+		"/bw/dayOfMonth/01":   []byte("40.000"),
+		"/bw/dayOfMonth/02":   []byte("39.500"),
+		"/bw/bwPerDayRemain":  []byte("39.500"),
+		"/bw/devices/tv/02":   []byte("3.000"),
+		"/bw/history/2023-01": []byte("1100"),
+	}
+	puts := map[string]string{
+		"/bw/bwCurrentUsed":  "560",
+		"/bw/bwPerDayRemain": "39.500", // unchanged, so left out
+		"/bw/dayOfMonth/03":  "38.900",
+	}
+
+	changes := auditChanges(current, puts, nil, []string{"/bw/dayOfMonth/", "/bw/devices/"})
+	expected := []auditChange{
+		{Key: "/bw/bwCurrentUsed", Old: stringRef("500"), New: stringRef("560")},
+		{Key: "/bw/dayOfMonth/03", New: stringRef("38.900")},
+		{Key: "/bw/dayOfMonth/01", Old: stringRef("40.000")},
+		{Key: "/bw/dayOfMonth/02", Old: stringRef("39.500")},
+		{Key: "/bw/devices/tv/02", Old: stringRef("3.000")},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, changes)
+	}
+}
+
+func TestAuditChangesExactDeletes(t *testing.T) {
+	current := map[string][]byte{"/bw/devices/pc/02": []byte("3.000"), "/bw/devices/pc2/02": []byte("1.000")}
+
+	// deleting a key on its own must not catch the keys it is a prefix of
+	changes := auditChanges(current, nil, []string{"/bw/devices/pc/02", "/bw/devices/pc"}, nil)
+	expected := []auditChange{{Key: "/bw/devices/pc/02", Old: stringRef("3.000")}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, changes)
+	}
+}
+
+func TestUndoChanges(t *testing.T) {
+	entry := auditEntry{ID: "20230203T101500.000000000Z-laptop", Changes: []auditChange{
+		{Key: "/bw/bwCurrentUsed", Old: stringRef("500"), New: stringRef("560")},
+		{Key: "/bw/dayOfMonth/03", New: stringRef("38.900")},
+		{Key: "/bw/dayOfMonth/01", Old: stringRef("40.000")},
+	}}
+
+	puts, deletes := undoChanges(entry)
+	expectedPuts := map[string]string{"/bw/bwCurrentUsed": "500", "/bw/dayOfMonth/01": "40.000"}
+	if !reflect.DeepEqual(puts, expectedPuts) || !reflect.DeepEqual(deletes, []string{"/bw/dayOfMonth/03"}) {
+		t.Errorf("ERROR: Expected: %v, [/bw/dayOfMonth/03] got: %v, %v", expectedPuts, puts, deletes)
+	}
+
+	tests := []struct {
+		name     string
+		current  map[string][]byte
+		expected []string
+	}{
+		{"Check untouched since", map[string][]byte{"/bw/bwCurrentUsed": []byte("560"), "/bw/dayOfMonth/03": []byte("38.900")}, []string{}},
+		{"Check changed again", map[string][]byte{"/bw/bwCurrentUsed": []byte("610"), "/bw/dayOfMonth/03": []byte("38.900")}, []string{"/bw/bwCurrentUsed"}},
+		{"Check deleted since", map[string][]byte{"/bw/bwCurrentUsed": []byte("560")}, []string{"/bw/dayOfMonth/03"}},
+		{"Check deleted key written again", map[string][]byte{"/bw/bwCurrentUsed": []byte("560"), "/bw/dayOfMonth/03": []byte("38.900"),
+			"/bw/dayOfMonth/01": []byte("41.000")}, []string{"/bw/dayOfMonth/01"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if conflicts := undoConflicts(entry, test.current); !reflect.DeepEqual(conflicts, test.expected) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, conflicts)
+			}
+		})
+	}
+}
+
+func TestParseAuditEntries(t *testing.T) {
+	older := auditEntry{ID: auditID(time.Date(2023, 2, 3, 10, 15, 0, 0, time.UTC), "Laptop"), Op: auditSave}
+	newer := auditEntry{ID: auditID(time.Date(2023, 2, 3, 18, 2, 0, 0, time.UTC), "Desktop PC"), Op: auditDeleteDay}
+	values := map[string][]byte{"/bw/bwCurrentUsed": []byte("560"), "/bw/audit/broken": []byte("{")}
+	for _, entry := range []auditEntry{older, newer} {
+		value, _ := json.Marshal(entry)
+		values["/bw/audit/"+entry.ID] = value
+	}
+
+	entries := parseAuditEntries(values, "/bw/audit/")
+	if len(entries) != 2 || entries[0].ID != "20230203T180200.000000000Z-desktop-pc" || entries[1].ID != "20230203T101500.000000000Z-laptop" {
+		t.Errorf("ERROR: Expected: newest first got: %v", entries)
+	}
+}
+
+func TestExpiredAuditNames(t *testing.T) {
+	names := []string{
+		"home/audit/" + auditID(time.Date(2022, 1, 31, 23, 0, 0, 0, time.UTC), "laptop"),
+		"home/audit/" + auditID(time.Date(2022, 2, 1, 9, 0, 0, 0, time.UTC), "laptop"),
+		"home/bwCurrentUsed",
+		"cabin/audit/" + auditID(time.Date(2021, 5, 1, 9, 0, 0, 0, time.UTC), "laptop"),
+	}
+
+	expired := expiredAuditNames(names, "home/audit/", time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC))
+	if !reflect.DeepEqual(expired, names[:1]) {
+		t.Errorf("ERROR: Expected: %v got: %v", names[:1], expired)
+	}
+}
+
+func TestFormatAuditEntries(t *testing.T) {
+	entry := auditEntry{ID: "20230203T101500.000000000Z-laptop", Time: time.Date(2023, 2, 3, 10, 15, 0, 0, time.Local),
+		User: "nate", Host: "laptop", Op: auditRollover, Changes: []auditChange{
+			{Key: "/bw/history/2023-01/bwCurrentUsed", New: stringRef("1100")},
+			{Key: "/bw/dayOfMonth/01", Old: stringRef("40.000")},
+			{Key: "/bw/dayOfMonth/02", Old: stringRef("39.500")},
+		}}
+
+	output := formatAuditEntries([]auditEntry{entry}, "/bw/", 2)
+	expected := "20230203T101500.000000000Z-laptop  2023-02-03 10:15:00  nate@laptop  rollover (3 changes)\r\n" +
+		"    history/2023-01/bwCurrentUsed: (none) -> 1100\r\n" +
+		"    dayOfMonth/01: 40.000 -> (none)\r\n" +
+		"    ... and 1 more\r\n"
+	if output != expected {
+		t.Errorf("ERROR: Expected: %q got: %q", expected, output)
+	}
+}
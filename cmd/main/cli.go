@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"gopkg.in/yaml.v2"
+)
+
+const usage = `Usage: CalcBandwidth [options] [command]
+
+With no command the calculator window opens.
+
+Commands:
+  config check    print the effective configuration and any problems with it
+  summary         print every profile's usage this cycle and the combined total
+  advise          print the daily target and what to do to stay under the cap
+  enforce         shape or lift the router's bandwidth as configured under enforce,
+                  for running on a schedule
+  audit           list the changes made to the stored values, newest first
+  audit undo [--force] <id>
+                  put back what a change did. Values changed again since are only
+                  overwritten, and changes too big for one etcd transaction only
+                  undone in parts, with --force
+  record [--category <name>] [--at <YYYY-MM-DD HH:MM>] <device> <GB>
+                  add usage for a device (defaults to now), for collectors. Usage
+                  in an unmetered window or zero-rated category is left off the cap
+
+Options:
+`
+
+const usageEnv = `
+Every option except --config can also be set with a CALCBW_ environment variable,
+for example CALCBW_ENDPOINTS or CALCBW_BASE_KEY. Options beat the environment,
+which beats config.yml. Without --config (or CALCBW_CONFIG) config.yml is looked
+for in the working directory, next to the executable, then in the CalcBandwidth
+folder under %AppData% or $XDG_CONFIG_HOME.
+`
+
+// Settings picked up from the command line and environment at startup
+type startupOptions struct {
+	configPath string
+	overrides  map[string]string // setting name to value, see configOverrides
+	args       []string          // what's left after the options, the command if any
+}
+
+// Parses the options in front of the command, overrides given as options take precedence over
+// the environment
+func parseOptions(args []string, getenv func(string) string, output io.Writer) (startupOptions, error) {
+	opts := startupOptions{overrides: envOverrides(getenv)}
+
+	flags := flag.NewFlagSet("CalcBandwidth", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		fmt.Fprint(output, usage)
+		flags.PrintDefaults()
+		fmt.Fprint(output, usageEnv)
+	}
+	flags.StringVar(&opts.configPath, "config", "", "path to config.yml")
+	for _, override := range configOverrides {
+		name := override.name
+		flags.Func(name, override.usage, func(value string) error {
+			opts.overrides[name] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	opts.args = flags.Args()
+
+	return opts, nil
+}
+
+// Runs a command line command instead of the gui, returns false if there wasn't one
+func runCommand(args []string, configPath string, overrides map[string]string) (bool, int) {
+	if len(args) == 0 {
+		return false, 0
+	}
+	attachConsole()
+
+	switch {
+	case len(args) == 2 && args[0] == "config" && args[1] == "check":
+		return true, configCheck(os.Stdout, configPath, overrides)
+	case len(args) == 1 && args[0] == "summary":
+		return true, summaryCommand(os.Stdout, configPath, overrides)
+	case len(args) == 1 && args[0] == "advise":
+		return true, adviseCommand(os.Stdout, configPath, overrides)
+	case len(args) == 1 && args[0] == "enforce":
+		return true, enforceCommand(os.Stdout, configPath, overrides)
+	case len(args) > 0 && args[0] == "audit":
+		return true, auditCommand(os.Stdout, args[1:], configPath, overrides)
+	case len(args) > 0 && args[0] == "record":
+		return true, recordCommand(os.Stdout, args[1:], configPath, overrides)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return true, 2
+	}
+}
+
+// Prints the config as it will be used and every problem found with it, returning the exit code
+func configCheck(w io.Writer, configPath string, overrides map[string]string) int {
+	var cfg Config
+	if err := cfg.load(configPath, overrides); err != nil {
+		fmt.Fprintf(w, "Could not load config: %v\n", err)
+		return 1
+	}
+
+	out, err := yaml.Marshal(cfg.effective())
+	if err != nil {
+		fmt.Fprintf(w, "Could not print config: %v\n", err)
+		return 1
+	}
+	source := configPath
+	if source == "" {
+		source = "defaults (no config.yml found)"
+	}
+	if len(overrides) > 0 {
+		source += " with " + strings.Join(sortedStringKeys(overrides), ", ") + " overridden"
+	}
+	fmt.Fprintf(w, "# effective configuration from %s\n%s\n", source, out)
+
+	errs := cfg.validate()
+	if len(cfg.Etcd.Endpoints) == 0 {
+		fmt.Fprintln(w, "No etcd endpoints, values will only be kept in the registry")
+	}
+	if len(errs) == 0 {
+		fmt.Fprintln(w, "Config OK")
+		return 0
+	}
+	fmt.Fprintf(w, "%d problem(s) found:\n", len(errs))
+	for _, err := range errs {
+		fmt.Fprintf(w, "  - %v\n", err)
+	}
+
+	return 1
+}
+
+// Prints the combined summary of every profile, returning the exit code
+func summaryCommand(w io.Writer, configPath string, overrides map[string]string) int {
+	mw := &MainWin{configPath: configPath, overrides: overrides}
+	if err := mw.config.load(configPath, overrides); err != nil {
+		fmt.Fprintf(w, "Could not load config: %v\n", err)
+		return 1
+	}
+	if err := mw.openStore(); err != nil {
+		fmt.Fprintf(w, "Could not open storage: %v\n", err)
+		return 1
+	}
+	defer mw.etcd.close()
+
+	summary, err := mw.profileSummary()
+	if err != nil {
+		fmt.Fprintf(w, "Could not build summary: %v\n", err)
+		return 1
+	}
+	fmt.Fprint(w, strings.ReplaceAll(summary, "\r\n", "\n"))
+
+	return 0
+}
+
+// Parses the record command's arguments
+func parseRecordArgs(args []string, now time.Time) (device, category string, gb float64, at time.Time, err error) {
+	flags := flag.NewFlagSet("record", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&category, "category", "", "zero-rated category the usage belongs to")
+	atText := flags.String("at", "", "when the usage happened, YYYY-MM-DD HH:MM")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 2 {
+		err = errors.New("expected a device and the GB it used")
+		return
+	}
+	device = flags.Arg(0)
+	if gb, err = strconv.ParseFloat(flags.Arg(1), 64); err != nil || gb < 0 {
+		err = fmt.Errorf("%q is not a number of GB", flags.Arg(1))
+		return
+	}
+	at = now
+	if *atText != "" {
+		if at, err = time.ParseInLocation("2006-01-02 15:04", *atText, now.Location()); err != nil {
+			err = fmt.Errorf("%q is not a YYYY-MM-DD HH:MM time", *atText)
+		}
+	}
+
+	return
+}
+
+// Records usage for a device from the command line, returning the exit code
+func recordCommand(w io.Writer, args []string, configPath string, overrides map[string]string) int {
+	device, category, gb, at, err := parseRecordArgs(args, time.Now())
+	if err != nil {
+		fmt.Fprintf(w, "%v\n%s", err, usage)
+		return 2
+	}
+	mw := &MainWin{configPath: configPath, overrides: overrides, dataDir: dataDirFor(configPath)}
+	if err := mw.config.load(configPath, overrides); err != nil {
+		fmt.Fprintf(w, "Could not load config: %v\n", err)
+		return 1
+	}
+	if mw.profile, err = mw.config.useProfile(""); err != nil {
+		fmt.Fprintf(w, "Could not pick profile: %v\n", err)
+		return 1
+	}
+	if err := mw.openStore(); err != nil {
+		fmt.Fprintf(w, "Could not open storage: %v\n", err)
+		return 1
+	}
+	defer mw.etcd.close()
+	// so anything queued if etcd goes away is added to what it held
+	if err := mw.readStoredValues(); err != nil {
+		fmt.Fprintf(w, "Could not read values: %v\n", err)
+		return 1
+	}
+
+	unmetered, err := mw.recordDeviceUsage(device, category, gb, at)
+	if err != nil {
+		fmt.Fprintf(w, "Could not record usage: %v\n", err)
+		return 1
+	}
+	switch {
+	case mw.degraded:
+		fmt.Fprintf(w, "Etcd unreachable, queued %.3f GB for %s\n", gb, device)
+	case unmetered:
+		fmt.Fprintf(w, "Recorded %.3f GB for %s, unmetered\n", gb, device)
+	default:
+		fmt.Fprintf(w, "Recorded %.3f GB for %s\n", gb, device)
+	}
+
+	return 0
+}
+
+// Prints the budget advice for the profile in use, returning the exit code
+func adviseCommand(w io.Writer, configPath string, overrides map[string]string) int {
+	mw := &MainWin{configPath: configPath, overrides: overrides}
+	if err := mw.config.load(configPath, overrides); err != nil {
+		fmt.Fprintf(w, "Could not load config: %v\n", err)
+		return 1
+	}
+	var err error
+	if mw.profile, err = mw.config.useProfile(""); err != nil {
+		fmt.Fprintf(w, "Could not pick profile: %v\n", err)
+		return 1
+	}
+	if err := mw.openStore(); err != nil {
+		fmt.Fprintf(w, "Could not open storage: %v\n", err)
+		return 1
+	}
+	defer mw.etcd.close()
+	if err := mw.readStoredValues(); err != nil {
+		fmt.Fprintf(w, "Could not read values: %v\n", err)
+		return 1
+	}
+
+	if _, err := mw.calculateBandwidth(); err != nil {
+		fmt.Fprintf(w, "Could not calculate from the stored readings: %v\n", err)
+		return 1
+	}
+	fmt.Fprint(w, strings.ReplaceAll(formatAdvice(mw.advice), "\r\n", "\n"))
+
+	return 0
+}
+
+// Parses the audit command's arguments, returning the id to undo if any
+func parseAuditArgs(args []string) (undoID string, force bool, err error) {
+	if len(args) == 0 {
+		return "", false, nil
+	}
+	if args[0] != "undo" {
+		return "", false, fmt.Errorf("unknown audit command %q", args[0])
+	}
+	flags := flag.NewFlagSet("audit undo", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&force, "force", false, "overwrite values changed again since")
+	if err = flags.Parse(args[1:]); err != nil {
+		return "", false, err
+	}
+	if flags.NArg() != 1 {
+		return "", false, errors.New("expected the id of the change to undo")
+	}
+
+	return flags.Arg(0), force, nil
+}
+
+// Lists the audit trail of the profile in use or undoes one change, returning the exit code
+func auditCommand(w io.Writer, args []string, configPath string, overrides map[string]string) int {
+	undoID, force, err := parseAuditArgs(args)
+	if err != nil {
+		fmt.Fprintf(w, "%v\n%s", err, usage)
+		return 2
+	}
+	mw := &MainWin{configPath: configPath, overrides: overrides, dataDir: dataDirFor(configPath)}
+	if err := mw.config.load(configPath, overrides); err != nil {
+		fmt.Fprintf(w, "Could not load config: %v\n", err)
+		return 1
+	}
+	if mw.profile, err = mw.config.useProfile(""); err != nil {
+		fmt.Fprintf(w, "Could not pick profile: %v\n", err)
+		return 1
+	}
+	if err := mw.openStore(); err != nil {
+		fmt.Fprintf(w, "Could not open storage: %v\n", err)
+		return 1
+	}
+	defer mw.etcd.close()
+
+	if undoID != "" {
+		if err := mw.undoAudit(undoID, force); err != nil {
+			fmt.Fprintf(w, "Could not undo %s: %v\n", undoID, err)
+			return 1
+		}
+		fmt.Fprintf(w, "Undid %s\n", undoID)
+		return 0
+	}
+	entries, err := mw.readAuditEntries()
+	if err != nil {
+		fmt.Fprintf(w, "Could not read the audit trail: %v\n", err)
+		return 1
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No changes recorded")
+		return 0
+	}
+	fmt.Fprint(w, strings.ReplaceAll(formatAuditEntries(entries, mw.config.Etcd.BaseKeyToWrite+"/", auditChangesShown), "\r\n", "\n"))
+
+	return 0
+}
+
+// Shapes or lifts the router's bandwidth for the profile it follows, returning the exit code
+func enforceCommand(w io.Writer, configPath string, overrides map[string]string) int {
+	mw := &MainWin{configPath: configPath, overrides: overrides, dataDir: dataDirFor(configPath)}
+	if err := mw.config.load(configPath, overrides); err != nil {
+		fmt.Fprintf(w, "Could not load config: %v\n", err)
+		return 1
+	}
+	if mw.config.Enforce.Router == "" {
+		fmt.Fprintln(w, "No router to enforce on, see enforce in config.yml")
+		return 1
+	}
+	var err error
+	if mw.profile, err = mw.config.useProfile(mw.config.Enforce.Profile); err != nil {
+		fmt.Fprintf(w, "Could not pick profile: %v\n", err)
+		return 1
+	}
+	if err := mw.openStore(); err != nil {
+		fmt.Fprintf(w, "Could not open storage: %v\n", err)
+		return 1
+	}
+	defer mw.etcd.close()
+	if err := mw.readStoredValues(); err != nil {
+		fmt.Fprintf(w, "Could not read values: %v\n", err)
+		return 1
+	}
+
+	if _, err := mw.calculateBandwidth(); err != nil {
+		fmt.Fprintf(w, "Could not calculate from the stored readings: %v\n", err)
+		return 1
+	}
+	if err := mw.enforceBudget(); err != nil {
+		fmt.Fprintf(w, "Could not update the router: %v\n", err)
+		return 1
+	}
+	if applied, _ := mw.getStoredValue(regValue14); applied != "" {
+		fmt.Fprintf(w, "Shaped to %d kbit since the %s cycle, %.2f GB per day left\n", mw.config.Enforce.RateKbit, applied, mw.config.gbPerDayLeft)
+	} else {
+		fmt.Fprintf(w, "Not shaped, %.2f GB per day left\n", mw.config.gbPerDayLeft)
+	}
+
+	return 0
+}
+
+// GUI builds (-H=windowsgui) have no console, so attach to the one we were started from
+// for command output. Does nothing when there is no such console
+func attachConsole() {
+	const attachParentProcess = ^uintptr(0) // (DWORD)-1
+	if handle, err := windows.GetStdHandle(windows.STD_OUTPUT_HANDLE); err == nil && handle != 0 {
+		return // already have somewhere to write, like a console build or redirected output
+	}
+	attach := windows.NewLazySystemDLL("kernel32.dll").NewProc("AttachConsole")
+	if ok, _, _ := attach.Call(attachParentProcess); ok == 0 {
+		return
+	}
+	if out, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0); err == nil {
+		os.Stdout = out
+		os.Stderr = out
+	}
+}
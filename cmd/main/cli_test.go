@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecordArgs(t *testing.T) {
+	now := time.Date(2024, 3, 20, 12, 0, 0, 0, time.Local)
+	device, category, gb, at, err := parseRecordArgs([]string{"--category", "updates", "--at", "2024-03-19 02:30", "xbox", "12.5"}, now)
+	if err != nil || device != "xbox" || category != "updates" || gb != 12.5 || !at.Equal(time.Date(2024, 3, 19, 2, 30, 0, 0, time.Local)) {
+		t.Errorf("ERROR: Expected: xbox updates 12.5 2024-03-19 02:30 got: %s %s %v %v %v", device, category, gb, at, err)
+	}
+	if _, _, _, at, err = parseRecordArgs([]string{"xbox", "1"}, now); err != nil || !at.Equal(now) {
+		t.Errorf("ERROR: Expected: %v got: %v, %v", now, at, err)
+	}
+	for _, args := range [][]string{{"xbox"}, {"xbox", "lots"}, {"--at", "yesterday", "xbox", "1"}} {
+		if _, _, _, _, err = parseRecordArgs(args, now); err == nil {
+			t.Errorf("ERROR: Expected: error for %v got: none", args)
+		}
+	}
+}
+
+func TestParseAuditArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		undoID string
+		force  bool
+		valid  bool
+	}{
+		{[]string{}, "", false, true},
+		{[]string{"undo", "20230203T101500.000000000Z-laptop"}, "20230203T101500.000000000Z-laptop", false, true},
+		{[]string{"undo", "--force", "20230203T101500.000000000Z-laptop"}, "20230203T101500.000000000Z-laptop", true, true},
+		{[]string{"undo"}, "", false, false},
+		{[]string{"redo", "x"}, "", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			undoID, force, err := parseAuditArgs(test.args)
+			if (err == nil) != test.valid || undoID != test.undoID || force != test.force {
+				t.Errorf("ERROR: Expected: %q, %v, valid %v got: %q, %v, %v", test.undoID, test.force, test.valid, undoID, force, err)
+			}
+		})
+	}
+}
+
+func TestFindMissingConfigFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "config.yml")
+	if path, err := findConfigFile(missing); err == nil {
+		t.Errorf("ERROR: Expected: error for --config %s got: %q", missing, path)
+	}
+	t.Setenv(envConfigPath, missing)
+	if path, err := findConfigFile(""); err == nil {
+		t.Errorf("ERROR: Expected: error for %s=%s got: %q", envConfigPath, missing, path)
+	}
+}
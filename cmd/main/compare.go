@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+	"github.com/wcharczuk/go-chart"
+)
+
+const (
+	compareGraphFilename = "compare.png"
+	defaultCompareMonths = 3
+)
+
+// Summary figures shown for a single cycle in the comparison table
+type monthStats struct {
+	month                string
+	total, avgDaily      float64
+	peakDay              string
+	peakValue            float64
+	daysWithData, inDays int
+}
+
+// Returns every archived month (as YYYY-MM) found under the history key, oldest first
+func getArchivedMonths(mw *MainWin) []string {
+	historySubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue7 + "/"
+	seen := map[string]bool{}
+	months := []string{}
+
+	for k := range mw.config.dbValues {
+		if !strings.HasPrefix(k, historySubkey) {
+			continue
+		}
+		month := strings.SplitN(k[len(historySubkey):], "/", 2)[0]
+		if !seen[month] {
+			seen[month] = true
+			months = append(months, month)
+		}
+	}
+	sort.Strings(months) // YYYY-MM sorts the same alphabetically as by date
+
+	return months
+}
+
+// Picks which archived months to compare against, either the last n cycles
+// or only the same month of last year. now is the start of the current cycle
+func selectCompareMonths(archived []string, n int, sameMonthLastYear bool, now time.Time) []string {
+	if sameMonthLastYear {
+		lastYear := fmt.Sprintf("%d-%02d", now.Year()-1, int(now.Month()))
+		for _, month := range archived {
+			if month == lastYear {
+				return []string{month}
+			}
+		}
+		return []string{}
+	}
+	if n < len(archived) {
+		return archived[len(archived)-n:]
+	}
+
+	return archived
+}
+
+// Works out total, daily average and peak day for one cycle from what was used each day. The
+// peak day is the one that used the most
+func getMonthStats(month string, daily map[string]float64, startDay int, total float64, inDays int) monthStats {
+	stats := monthStats{month: month, total: total, daysWithData: len(daily), inDays: inDays}
+	if inDays > 0 {
+		stats.avgDaily = total / float64(inDays)
+	}
+	for _, day := range cycleDayOrder(startDay) {
+		used, ok := daily[getStrDayOfMonth(day)]
+		if ok && (stats.peakDay == "" || used > stats.peakValue) {
+			stats.peakDay = getStrDayOfMonth(day)
+			stats.peakValue = used
+		}
+	}
+
+	return stats
+}
+
+// Builds the text table of monthly totals, average daily use and peak day
+func formatMonthStats(allStats []monthStats) string {
+	output := fmt.Sprintf("%-9s %10s %12s %10s %14s\r\n", "Month", "Used (GB)", "Avg/day (GB)", "Peak day", "Peak used (GB)")
+	for _, stats := range allStats {
+		if stats.peakDay == "" { // months archived before daily totals were kept
+			output += fmt.Sprintf("%-9s %10.0f %12.2f %10s %14s\r\n", stats.month, stats.total, stats.avgDaily, "-", "-")
+			continue
+		}
+		output += fmt.Sprintf("%-9s %10.0f %12.2f %10s %14.2f\r\n",
+			stats.month, stats.total, stats.avgDaily, stats.peakDay, stats.peakValue)
+	}
+
+	return output
+}
+
+// Turns bars into a line series with the day of month along the x axis
+func barsToSeries(name string, bars []chart.Value) chart.ContinuousSeries {
+	series := chart.ContinuousSeries{Name: name}
+	for _, bar := range bars {
+		day, _ := strconv.ParseFloat(bar.Label, 64)
+		series.XValues = append(series.XValues, day)
+		series.YValues = append(series.YValues, bar.Value)
+	}
+
+	return series
+}
+
+// Renders the current cycle overlaid on the chosen archived months and returns the stats table
+func (mw *MainWin) makeCompareChart(months []string) (string, error) {
+	start := cycleStart(time.Now(), mw.config.CycleStartDay)
+	current := start.Format("2006-01")
+	_, currentBars := getBarsData(mw)
+
+	allStats := []monthStats{}
+	series := []chart.Series{}
+	for _, month := range months {
+		historySubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue7 + "/" + month
+		_, bars := getBarsDataUnder(mw, historySubkey)
+		total, _ := strconv.ParseFloat(string(mw.config.dbValues[historySubkey+"/"+regValue1]), 64)
+
+		inDays := 0
+		if t, err := time.Parse("2006-01", month); err == nil {
+			inDays = int(cycleDays(t.AddDate(0, 0, mw.config.CycleStartDay-1)))
+		}
+		daily := dailyFromRunningTotals(getDaySeries(mw.config.dbValues, historySubkey+"/"+regValue10), mw.config.CycleStartDay)
+		allStats = append(allStats, getMonthStats(month, daily, mw.config.CycleStartDay, total, inDays))
+		if len(bars) > 0 {
+			series = append(series, barsToSeries(month, bars))
+		}
+	}
+	daysSoFar := int(time.Since(start).Hours()/24) + 1
+	daily := dailyFromRunningTotals(getDaySeries(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite+"/"+regValue10), mw.config.CycleStartDay)
+	allStats = append(allStats, getMonthStats(current+"*", daily, mw.config.CycleStartDay, mw.config.bwCurrentUsed, daysSoFar))
+	if len(currentBars) > 0 {
+		series = append(series, barsToSeries(current, currentBars))
+	}
+
+	table := formatMonthStats(allStats)
+	if len(series) == 0 {
+		return table, errors.New("no daily data to compare")
+	}
+
+	graph := chart.Chart{
+		Background: chart.Style{
+			Padding: chart.Box{
+				Top:   10,
+				Right: 10,
+			},
+		},
+		DPI:    1200,
+		Width:  initialWinWidth + 75,
+		Height: graphImgHeight - 150,
+		XAxis: chart.XAxis{
+			Style: chart.Style{
+				Show:     true,
+				FontSize: 1.2,
+			},
+			ValueFormatter: func(v interface{}) string {
+				return fmt.Sprintf("%.0f", v)
+			},
+		},
+		YAxis: chart.YAxis{
+			Style: chart.Style{
+				Show:     true,
+				FontSize: 1.2,
+			},
+			ValueFormatter: chart.FloatValueFormatter,
+		},
+		Series: series,
+	}
+	graph.Elements = []chart.Renderable{chart.Legend(&graph, chart.Style{FontSize: 1.2})}
+
+	file, err := os.OpenFile(compareGraphFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return table, errors.New("comparison chart file could not be opened")
+	}
+	defer file.Close()
+
+	if err = graph.Render(chart.PNG, file); err != nil {
+		return table, errors.New("comparison chart could not be rendered")
+	}
+
+	return table, nil
+}
+
+// Opens a window overlaying this cycle's daily series on previous cycles
+func (mw *MainWin) showCompareDialog() {
+	if !mw.useEtcd {
+		walk.MsgBox(mw, "Info", "Month history is only kept in etcd", walk.MsgBoxIconInformation)
+		return
+	}
+
+	var dlg *walk.Dialog
+	var countEdit *walk.NumberEdit
+	var lastYearCheckBox *walk.CheckBox
+	var tableBox *walk.TextEdit
+	var compareImage *walk.ImageView
+
+	refresh := func() {
+		months := selectCompareMonths(getArchivedMonths(mw), int(countEdit.Value()), lastYearCheckBox.Checked(),
+			cycleStart(time.Now(), mw.config.CycleStartDay))
+		table, err := mw.makeCompareChart(months)
+		if err != nil {
+			tableBox.SetText(table + "\r\n" + err.Error())
+			return
+		}
+		tableBox.SetText(table)
+
+		if img, err := walk.NewImageFromFileForDPI(compareGraphFilename, 600); err == nil {
+			compareImage.SetImage(img)
+		}
+	}
+
+	Dialog{
+		AssignTo: &dlg,
+		Title:    "Compare months",
+		MinSize:  Size{initialWinWidth, initialWinHeight - 200},
+		Layout:   VBox{},
+		Children: []Widget{
+			Composite{
+				Layout: HBox{
+					MarginsZero: true,
+				},
+				Children: []Widget{
+					Label{
+						Text: "Previous cycles:",
+					},
+					NumberEdit{
+						AssignTo: &countEdit,
+						Value:    float64(defaultCompareMonths),
+						MinValue: 1,
+						MaxValue: 24,
+					},
+					Label{
+						Text: "Same month last year:",
+					},
+					CheckBox{
+						AssignTo: &lastYearCheckBox,
+					},
+					PushButton{
+						Text: "   Compare   ",
+						OnClicked: func() {
+							refresh()
+						},
+					},
+				},
+			},
+			TextEdit{
+				AssignTo: &tableBox,
+				MinSize:  Size{initialWinWidth - 50, 120},
+				ReadOnly: true,
+				Font: Font{
+					Family:    "Courier New",
+					PointSize: 10,
+				},
+			},
+			ImageView{
+				AssignTo: &compareImage,
+				MinSize:  Size{initialWinWidth - 50, graphImgHeight - 200},
+				Margin:   4,
+				Mode:     ImageViewModeZoom,
+			},
+		},
+	}.Create(mw)
+
+	refresh()
+	dlg.Run()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSelectCompareMonths(t *testing.T) {
+	archived := []string{"2023-10", "2023-11", "2023-12", "2024-01", "2024-02"}
+	now := time.Date(2024, 11, 5, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name              string
+		n                 int
+		sameMonthLastYear bool
+		now               time.Time
+		expected          []string
+	}{
+		{"Check last 3 cycles", 3, false, now, []string{"2023-12", "2024-01", "2024-02"}},
+		{"Check more cycles than archived", 10, false, now, archived},
+		{"Check same month last year", 3, true, now, []string{"2023-11"}},
+		{"Check same month last year missing", 3, true, time.Date(2024, 6, 5, 0, 0, 0, 0, time.Local), []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := selectCompareMonths(archived, test.n, test.sameMonthLastYear, test.now)
+			if !reflect.DeepEqual(results, test.expected) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, results)
+			}
+		})
+	}
+}
+
+func TestGetMonthStats(t *testing.T) {
+	// running totals of 12, 20 then 45 GB, so the 28th used the most
+	totals := map[string]float64{"26": 12, "27": 20, "28": 45, "01": 53}
+	daily := dailyFromRunningTotals(totals, 26)
+
+	stats := getMonthStats("2024-01", daily, 26, 310, 31)
+	if stats.avgDaily != 10 {
+		t.Errorf("ERROR: Expected: %f got: %f", 10.0, stats.avgDaily)
+	}
+	if stats.peakDay != "28" || stats.peakValue != 25 {
+		t.Errorf("ERROR: Expected: %q, %f got: %q, %f", "28", 25.0, stats.peakDay, stats.peakValue)
+	}
+
+	if stats = getMonthStats("2023-12", map[string]float64{}, 26, 300, 31); stats.peakDay != "" {
+		t.Errorf("ERROR: Expected: no peak day got: %q", stats.peakDay)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"MyLibs/mysupport"
+)
+
+const (
+	defaultCapGB          = 1229
+	defaultBaseKeyToWrite = "/CalcBandwidth"
+	defaultEtcdPort       = "2379"
+	maxEtcdTimeout        = 60 // seconds, any longer and a hung cluster freezes the gui for too long
+	maxCycleStartDay      = 28 // so every month has the day a cycle starts on
+	configFilename        = "config.yml"
+	configDirName         = "CalcBandwidth" // folder under %AppData% or $XDG_CONFIG_HOME
+	envPrefix             = "CALCBW_"
+	envConfigPath         = envPrefix + "CONFIG"
+)
+
+// Config struct
+type Config struct {
+	Etcd                                      EtcdConfig
+	Cap                                       float64         `yaml:"cap"`           // monthly cap in GB
+	CycleStartDay                             int             `yaml:"cycleStartDay"` // day of month the billing cycle starts on
+	CapCounts                                 string          `yaml:"capCounts"`     // both (the default) or download
+	Unmetered                                 UnmeteredConfig `yaml:"unmetered"`
+	Pricing                                   Pricing         `yaml:"pricing"`
+	Rollover                                  RolloverPolicy  `yaml:"rollover"`
+	Enforce                                   EnforceConfig   `yaml:"enforce"`
+	LogLevel                                  string          `yaml:"logLevel"`
+	Profile                                   string          `yaml:"profile"` // profile to start on, defaults to the first
+	Profiles                                  []Profile       `yaml:"profiles"`
+	profileID                                 string          // slug of the profile in use, empty without profiles
+	dbValues                                  map[string][]byte
+	dbRevisions                               map[string]int64 // mod revision of each key when it was read
+	bwCurrentUsed, gbPerDayLeft, bwMin, bwMax float64
+	bwUploadUsed                              float64 // the upload part of bwCurrentUsed
+}
+
+// Etcd section of config.yml
+type EtcdConfig struct {
+	// var name has to be uppercase here or it won't work
+	Endpoints      []string `yaml:"endpoints"`
+	BaseKeyToWrite string   `yaml:"baseKeyToWrite"`
+	Timeout        int      `yaml:"timeout"`
+	CertPath       string   `yaml:"certpath"`
+	CAFile         string   `yaml:"caFile"` // the cert files can be absolute, or relative to certpath
+	CertFile       string   `yaml:"certFile"`
+	KeyFile        string   `yaml:"keyFile"`
+	ServerName     string   `yaml:"serverName"`    // overrides the name checked against the server cert
+	MinTLSVersion  string   `yaml:"minTLSVersion"` // 1.0 to 1.3, defaults to 1.2
+	Username       string   `yaml:"username"`
+	Password       string   `yaml:"password"`
+}
+
+// Fills in anything config.yml left out, and tidies up values that are easy to get slightly wrong
+func (cfg *Config) applyDefaults() {
+	if cfg.Cap == 0 {
+		cfg.Cap = defaultCapGB
+	}
+	if cfg.CycleStartDay == 0 {
+		cfg.CycleStartDay = 1
+	}
+	cfg.CapCounts = strings.ToLower(strings.TrimSpace(cfg.CapCounts))
+	if cfg.CapCounts == "" {
+		cfg.CapCounts = capCountsBoth
+	}
+	if cfg.Pricing.BlockSize == 0 {
+		cfg.Pricing.BlockSize = defaultBlockSizeGB
+	}
+	cfg.LogLevel = strings.ToLower(strings.TrimSpace(cfg.LogLevel))
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = defaultLogLevel
+	}
+	if cfg.Etcd.Timeout == 0 {
+		cfg.Etcd.Timeout = defaultEtcdTimeout
+	}
+	cfg.Etcd.BaseKeyToWrite = strings.TrimRight(strings.TrimSpace(cfg.Etcd.BaseKeyToWrite), "/")
+	if cfg.Etcd.BaseKeyToWrite == "" {
+		cfg.Etcd.BaseKeyToWrite = defaultBaseKeyToWrite
+	}
+	for i, endpoint := range cfg.Etcd.Endpoints {
+		cfg.Etcd.Endpoints[i] = withDefaultPort(strings.TrimSpace(endpoint))
+	}
+	cfg.applyProfileDefaults()
+}
+
+// Adds the default etcd port to an endpoint that doesn't have one
+func withDefaultPort(endpoint string) string {
+	host := endpoint
+	scheme := ""
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		scheme, host = endpoint[:i+3], endpoint[i+3:]
+	}
+	if host == "" {
+		return endpoint
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil && !strings.HasSuffix(host, ":") {
+		return scheme + net.JoinHostPort(strings.Trim(host, "[]"), defaultEtcdPort)
+	}
+
+	return endpoint
+}
+
+// Checks the config (after defaults are applied), returning one error per problem worded so the
+// user knows which setting in config.yml to fix
+func (cfg Config) validate() []error {
+	errs := []error{}
+
+	if cfg.Cap < 0 {
+		errs = append(errs, fmt.Errorf("cap must be a positive number of GB, got %v", cfg.Cap))
+	}
+	if cfg.CycleStartDay < 1 || cfg.CycleStartDay > maxCycleStartDay {
+		errs = append(errs, fmt.Errorf("cycleStartDay must be between 1 and %d, got %d", maxCycleStartDay, cfg.CycleStartDay))
+	}
+	if cfg.CapCounts != capCountsBoth && cfg.CapCounts != capCountsDownload {
+		errs = append(errs, fmt.Errorf("capCounts must be %s or %s, got %q", capCountsBoth, capCountsDownload, cfg.CapCounts))
+	}
+	if cfg.Etcd.Timeout < 0 || cfg.Etcd.Timeout > maxEtcdTimeout {
+		errs = append(errs, fmt.Errorf("etcd.timeout must be between 1 and %d seconds, got %d", maxEtcdTimeout, cfg.Etcd.Timeout))
+	}
+	if !strings.HasPrefix(cfg.Etcd.BaseKeyToWrite, "/") {
+		errs = append(errs, fmt.Errorf("etcd.baseKeyToWrite must start with /, got %q", cfg.Etcd.BaseKeyToWrite))
+	}
+
+	seen := map[string]bool{}
+	for _, endpoint := range cfg.Etcd.Endpoints {
+		if err := validateEndpoint(endpoint); err != nil {
+			errs = append(errs, err)
+		}
+		if seen[endpoint] {
+			errs = append(errs, fmt.Errorf("etcd.endpoints lists %q more than once", endpoint))
+		}
+		seen[endpoint] = true
+	}
+	if len(cfg.Etcd.Endpoints) > 0 {
+		errs = append(errs, cfg.Etcd.validateTLS()...)
+	}
+	errs = append(errs, cfg.Unmetered.validate("unmetered")...)
+	errs = append(errs, cfg.Pricing.validate("pricing")...)
+	errs = append(errs, cfg.Rollover.validate("rollover")...)
+	errs = append(errs, cfg.Enforce.validate()...)
+	if err := validateLogLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, cfg.validateProfiles()...)
+
+	return errs
+}
+
+// Checks an endpoint is host:port (optionally with an http or https scheme)
+func validateEndpoint(endpoint string) error {
+	host := endpoint
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		if scheme := endpoint[:i]; scheme != "http" && scheme != "https" {
+			return fmt.Errorf("etcd.endpoints %q has scheme %q, use https or leave it out", endpoint, scheme)
+		}
+		host = endpoint[i+3:]
+	}
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return fmt.Errorf("etcd.endpoints %q is not host:port", endpoint)
+	}
+	if hostname == "" {
+		return fmt.Errorf("etcd.endpoints %q is missing the host", endpoint)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("etcd.endpoints %q has an invalid port %q", endpoint, port)
+	}
+
+	return nil
+}
+
+// Returns the config as it will actually be used (defaults applied), with the password hidden
+func (cfg Config) effective() Config {
+	effective := cfg
+	effective.Etcd.Endpoints = append([]string{}, cfg.Etcd.Endpoints...)
+	effective.Profiles = append([]Profile{}, cfg.Profiles...)
+	effective.applyDefaults()
+	if effective.Etcd.Password != "" {
+		effective.Etcd.Password = "********"
+	}
+	if effective.Enforce.Password != "" {
+		effective.Enforce.Password = "********"
+	}
+
+	return effective
+}
+
+// A setting that can be given on the command line (--name) or in the environment
+// (CALCBW_NAME, dashes as underscores), overriding config.yml
+type configOverride struct {
+	name, usage string
+	set         func(cfg *Config, value string) error
+}
+
+var configOverrides = []configOverride{
+	{"endpoints", "comma separated etcd endpoints", func(cfg *Config, value string) error {
+		cfg.Etcd.Endpoints = []string{}
+		for _, endpoint := range strings.Split(value, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				cfg.Etcd.Endpoints = append(cfg.Etcd.Endpoints, endpoint)
+			}
+		}
+		return nil
+	}},
+	{"base-key", "etcd key everything is stored under", func(cfg *Config, value string) error {
+		cfg.Etcd.BaseKeyToWrite = value
+		return nil
+	}},
+	{"timeout", "etcd timeout in seconds", func(cfg *Config, value string) error {
+		timeout, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number of seconds", value)
+		}
+		cfg.Etcd.Timeout = timeout
+		return nil
+	}},
+	{"cert-path", "folder holding the etcd certs", func(cfg *Config, value string) error {
+		cfg.Etcd.CertPath = value
+		return nil
+	}},
+	{"ca-file", "etcd CA cert", func(cfg *Config, value string) error {
+		cfg.Etcd.CAFile = value
+		return nil
+	}},
+	{"cert-file", "etcd client cert", func(cfg *Config, value string) error {
+		cfg.Etcd.CertFile = value
+		return nil
+	}},
+	{"key-file", "etcd client key", func(cfg *Config, value string) error {
+		cfg.Etcd.KeyFile = value
+		return nil
+	}},
+	{"server-name", "name checked against the etcd server cert", func(cfg *Config, value string) error {
+		cfg.Etcd.ServerName = value
+		return nil
+	}},
+	{"min-tls-version", "lowest TLS version allowed, 1.0 to 1.3", func(cfg *Config, value string) error {
+		cfg.Etcd.MinTLSVersion = value
+		return nil
+	}},
+	{"username", "etcd username", func(cfg *Config, value string) error {
+		cfg.Etcd.Username = value
+		return nil
+	}},
+	{"password", "etcd password", func(cfg *Config, value string) error {
+		cfg.Etcd.Password = value
+		return nil
+	}},
+	{"cap", "monthly cap in GB", func(cfg *Config, value string) error {
+		capGB, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number of GB", value)
+		}
+		cfg.Cap = capGB
+		return nil
+	}},
+	{"cycle-start-day", "day of month the billing cycle starts on", func(cfg *Config, value string) error {
+		day, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a day of the month", value)
+		}
+		cfg.CycleStartDay = day
+		return nil
+	}},
+	{"cap-counts", "what the cap counts, both or download", func(cfg *Config, value string) error {
+		cfg.CapCounts = value
+		return nil
+	}},
+	{"profile", "profile to start on", func(cfg *Config, value string) error {
+		cfg.Profile = value
+		return nil
+	}},
+	{"log-level", "level to log at, debug, info, warn or error", func(cfg *Config, value string) error {
+		cfg.LogLevel = value
+		return nil
+	}},
+}
+
+// Returns the environment variable that overrides the named setting
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Collects the overrides set in the environment, keyed by setting name
+func envOverrides(getenv func(string) string) map[string]string {
+	overrides := map[string]string{}
+	for _, override := range configOverrides {
+		if value := getenv(envName(override.name)); value != "" {
+			overrides[override.name] = value
+		}
+	}
+
+	return overrides
+}
+
+// Applies the overrides on top of whatever was loaded from config.yml
+func (cfg *Config) applyOverrides(overrides map[string]string) []error {
+	errs := []error{}
+	for _, override := range configOverrides {
+		value, ok := overrides[override.name]
+		if !ok {
+			continue
+		}
+		if err := override.set(cfg, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s (or --%s): %v", envName(override.name), override.name, err))
+		}
+	}
+
+	return errs
+}
+
+// Returns the places config.yml is looked for, in order. The working directory comes first (with
+// the cmd or bin folder stripped when run from the source tree), then next to the executable,
+// then the per user config folder (%AppData% on Windows, $XDG_CONFIG_HOME or ~/.config elsewhere)
+func configSearchPaths(workDir, exeDir, userConfigDir, xdgConfigHome string) []string {
+	dirs := []string{}
+	if workDir != "" {
+		if base := filepath.Base(workDir); base == "cmd" || base == "bin" {
+			workDir = filepath.Dir(workDir)
+		}
+		dirs = append(dirs, workDir)
+	}
+	if exeDir != "" {
+		dirs = append(dirs, exeDir)
+	}
+	if userConfigDir != "" {
+		dirs = append(dirs, filepath.Join(userConfigDir, configDirName))
+	}
+	if xdgConfigHome != "" { // honoured on Windows too, for anyone sharing a config with WSL or msys
+		dirs = append(dirs, filepath.Join(xdgConfigHome, configDirName))
+	}
+
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, configFilename)
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// Works out which config file to use. An explicit path (--config or CALCBW_CONFIG) has to
+// exist, otherwise the first search path that exists is used. Returns "" if there is no config
+// file at all, in which case everything comes from the defaults and overrides
+func findConfigFile(explicit string) (string, error) {
+	if explicit == "" {
+		explicit = os.Getenv(envConfigPath)
+	}
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", &storeError{Store: "config", Op: "find", Key: explicit, Err: err}
+		}
+		return explicit, nil
+	}
+
+	workDir, _ := os.Getwd()
+	exeDir := ""
+	if exe, err := os.Executable(); err == nil {
+		exeDir = filepath.Dir(exe)
+	}
+	userConfigDir, _ := os.UserConfigDir()
+	for _, path := range configSearchPaths(workDir, exeDir, userConfigDir, os.Getenv("XDG_CONFIG_HOME")) {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Returns true if anything used to connect to etcd differs, the base key is only used once
+// connected so changing it doesn't need a new connection
+func connectionSettingsChanged(previous, current EtcdConfig) bool {
+	previous.BaseKeyToWrite, current.BaseKeyToWrite = "", ""
+
+	return !reflect.DeepEqual(previous, current)
+}
+
+// Returns the folder files we write (like the journal) are kept in, next to config.yml if there
+// is one, otherwise next to the executable
+func dataDirFor(configPath string) string {
+	if configPath != "" {
+		return filepath.Dir(configPath)
+	}
+	if exe, err := os.Executable(); err == nil {
+		return filepath.Dir(exe)
+	}
+	workDir, _ := os.Getwd()
+
+	return workDir
+}
+
+// Loads the config file (if there is one) into cfg, then applies the overrides and defaults.
+// Only the settings are replaced, the values read from the store are left alone
+func (cfg *Config) load(path string, overrides map[string]string) error {
+	settings := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < settings.NumField(); i++ {
+		if settings.Type().Field(i).IsExported() {
+			settings.Field(i).SetZero() // so settings removed from the file don't linger
+		}
+	}
+
+	if path != "" {
+		if err := mysupport.GetConfigContentsFromYaml(path, cfg); err != nil {
+			return &storeError{Store: "config", Op: "load", Key: path, Err: err}
+		}
+	}
+	if errs := cfg.applyOverrides(overrides); len(errs) > 0 {
+		return &storeError{Store: "config", Op: "override", Err: errors.Join(errs...)}
+	}
+	cfg.applyDefaults()
+	setLogLevel(cfg.LogLevel)
+
+	return nil
+}
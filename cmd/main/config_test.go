@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	cfg := Config{}
+	cfg.Etcd.Endpoints = []string{"10.150.30.21", " 10.150.30.22:2380 ", "https://etcd.lan", "[fd00::1]"}
+	cfg.Etcd.BaseKeyToWrite = "/nate/CalcBandwidth/"
+	cfg.applyDefaults()
+
+	expectedEndpoints := []string{"10.150.30.21:2379", "10.150.30.22:2380", "https://etcd.lan:2379", "[fd00::1]:2379"}
+	if !reflect.DeepEqual(cfg.Etcd.Endpoints, expectedEndpoints) {
+		t.Errorf("ERROR: Expected: %v got: %v", expectedEndpoints, cfg.Etcd.Endpoints)
+	}
+	if cfg.Etcd.BaseKeyToWrite != "/nate/CalcBandwidth" {
+		t.Errorf("ERROR: Expected: %q got: %q", "/nate/CalcBandwidth", cfg.Etcd.BaseKeyToWrite)
+	}
+	if cfg.Cap != defaultCapGB || cfg.Etcd.Timeout != defaultEtcdTimeout {
+		t.Errorf("ERROR: Expected: %d, %d got: %v, %d", defaultCapGB, defaultEtcdTimeout, cfg.Cap, cfg.Etcd.Timeout)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(cfg *Config)
+		expErrors int
+	}{
+		{"Check registry only config", func(cfg *Config) {}, 0},
+		{"Check negative cap", func(cfg *Config) { cfg.Cap = -5 }, 1},
+		{"Check timeout too long", func(cfg *Config) { cfg.Etcd.Timeout = 600 }, 1},
+		{"Check relative base key", func(cfg *Config) { cfg.Etcd.BaseKeyToWrite = "nate" }, 1},
+		{"Check unknown cap counts", func(cfg *Config) { cfg.CapCounts = "upload" }, 1},
+		{"Check unknown log level", func(cfg *Config) { cfg.LogLevel = "verbose" }, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Config{}
+			cfg.applyDefaults()
+			test.modify(&cfg)
+			if errs := cfg.validate(); len(errs) != test.expErrors {
+				t.Errorf("ERROR: Expected: %d errors got: %v", test.expErrors, errs)
+			}
+		})
+	}
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		valid    bool
+	}{
+		{"10.150.30.21:2379", true},
+		{"https://etcd.lan:2379", true},
+		{"10.150.30.21:", false},
+		{"10.150.30.21:99999", false},
+		{":2379", false},
+		{"unix://etcd.sock:2379", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			if err := validateEndpoint(test.endpoint); (err == nil) != test.valid {
+				t.Errorf("ERROR: Expected valid: %v got: %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestConfigOverrides(t *testing.T) {
+	env := map[string]string{
+		"CALCBW_ENDPOINTS": "10.0.0.1:2379, 10.0.0.2:2379",
+		"CALCBW_BASE_KEY":  "/env/CalcBandwidth",
+		"CALCBW_CAP":       "2000",
+		"CALCBW_TIMEOUT":   "10",
+	}
+	opts, err := parseOptions([]string{"--cap", "1500", "--ca-file", "root.pem", "config", "check"},
+		func(name string) string { return env[name] }, io.Discard)
+	if err != nil {
+		t.Fatalf("ERROR: Expected: no error got: %v", err)
+	}
+	if !reflect.DeepEqual(opts.args, []string{"config", "check"}) {
+		t.Errorf("ERROR: Expected: %v got: %v", []string{"config", "check"}, opts.args)
+	}
+
+	cfg := Config{Cap: 1229}
+	cfg.Etcd.Endpoints = []string{"10.150.30.21:2379"}
+	if errs := cfg.applyOverrides(opts.overrides); len(errs) != 0 {
+		t.Fatalf("ERROR: Expected: no errors got: %v", errs)
+	}
+	expectedEndpoints := []string{"10.0.0.1:2379", "10.0.0.2:2379"}
+	if !reflect.DeepEqual(cfg.Etcd.Endpoints, expectedEndpoints) {
+		t.Errorf("ERROR: Expected: %v got: %v", expectedEndpoints, cfg.Etcd.Endpoints)
+	}
+	if cfg.Cap != 1500 { // the command line beats the environment
+		t.Errorf("ERROR: Expected: %v got: %v", 1500, cfg.Cap)
+	}
+	if cfg.Etcd.BaseKeyToWrite != "/env/CalcBandwidth" || cfg.Etcd.Timeout != 10 || cfg.Etcd.CAFile != "root.pem" {
+		t.Errorf("ERROR: Expected: /env/CalcBandwidth, 10, root.pem got: %s, %d, %s", cfg.Etcd.BaseKeyToWrite, cfg.Etcd.Timeout, cfg.Etcd.CAFile)
+	}
+
+	if errs := cfg.applyOverrides(map[string]string{"timeout": "soon", "cap": "lots"}); len(errs) != 2 {
+		t.Errorf("ERROR: Expected: 2 errors got: %v", errs)
+	}
+	if _, err := parseOptions([]string{"--no-such-option"}, func(string) string { return "" }, io.Discard); err == nil {
+		t.Errorf("ERROR: Expected: an error for an unknown option but got none")
+	}
+}
+
+func TestConfigSearchPaths(t *testing.T) {
+	root := filepath.Join("home", "nate")
+	tests := []struct {
+		name                                      string
+		workDir, exeDir, userConfigDir, xdgConfig string
+		expected                                  []string
+	}{
+		{"Check source tree cmd folder is stripped", filepath.Join(root, "src", "cmd"), filepath.Join(root, "bin"), "", "",
+			[]string{filepath.Join(root, "src", configFilename), filepath.Join(root, "bin", configFilename)}},
+		{"Check duplicates are dropped", filepath.Join(root, "bin"), root, filepath.Join(root, ".config"), filepath.Join(root, ".config"),
+			[]string{filepath.Join(root, configFilename), filepath.Join(root, ".config", configDirName, configFilename)}},
+		{"Check nothing known", "", "", "", "", []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := configSearchPaths(test.workDir, test.exeDir, test.userConfigDir, test.xdgConfig)
+			if !reflect.DeepEqual(paths, test.expected) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, paths)
+			}
+		})
+	}
+}
+
+func TestConnectionSettingsChanged(t *testing.T) {
+	previous := EtcdConfig{Endpoints: []string{"10.150.30.21:2379"}, BaseKeyToWrite: "/nate/CalcBandwidth", Timeout: 5}
+	tests := []struct {
+		name     string
+		modify   func(cfg *EtcdConfig)
+		expected bool
+	}{
+		{"Check nothing changed", func(cfg *EtcdConfig) {}, false},
+		{"Check only base key changed", func(cfg *EtcdConfig) { cfg.BaseKeyToWrite = "/cabin/CalcBandwidth" }, false},
+		{"Check endpoint added", func(cfg *EtcdConfig) { cfg.Endpoints = append(cfg.Endpoints, "10.150.30.22:2379") }, true},
+		{"Check password changed", func(cfg *EtcdConfig) { cfg.Password = "changeme" }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := previous
+			current.Endpoints = append([]string{}, previous.Endpoints...)
+			test.modify(&current)
+			if changed := connectionSettingsChanged(previous, current); changed != test.expected {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lxn/walk"
+)
+
+const maxConflictRetries = 3
+
+// Merges the values we are about to write with what another client stored since we read them.
+// Keys nobody else touched keep our value. Days we only interpolated give way to whatever the
+// other client wrote, and the chart extents and month are ours since they only depend on the
+// series. Anything else that differs is a conflict the user has to decide on
+func mergeConcurrentWrites(ours, interpolated map[string]string, known map[string]int64,
+	theirs map[string][]byte, theirRevs map[string]int64) (map[string]string, []string) {
+	merged := map[string]string{}
+	conflicts := []string{}
+
+	for k, v := range ours {
+		theirValue, exists := theirs[k]
+		switch {
+		case theirRevs[k] == known[k], exists && string(theirValue) == v:
+			merged[k] = v
+		case !exists: // the other client deleted it, so put back what we have
+			merged[k] = v
+		case interpolated[k] != "":
+			// keep theirs, which won't be any worse than our guess
+		case strings.HasSuffix(k, "/"+regValue4), strings.HasSuffix(k, "/"+regValue5), strings.HasSuffix(k, "/"+regValue6):
+			merged[k] = v
+		default:
+			merged[k] = v
+			conflicts = append(conflicts, k)
+		}
+	}
+	sort.Strings(conflicts)
+
+	return merged, conflicts
+}
+
+// Writes the values only if no other client changed them since we last read, otherwise
+// re-reads, merges the daily entries and asks the user about anything that really conflicts
+func (mw *MainWin) commitCheckingConflicts(values, interpolated map[string]string) error {
+	known := mw.config.dbRevisions
+	for attempt := 1; attempt <= maxConflictRetries; attempt++ {
+		expected := map[string]int64{}
+		for k := range values {
+			expected[k] = known[k]
+		}
+		ok, err := mw.etcd.commitIf(mw.withAudit(auditSave, mw.config.dbValues, values), expected, nil)
+		if err != nil || ok {
+			return err
+		}
+
+		// somebody else wrote first, so get what they wrote and merge with ours
+		theirs, theirRevs, err := mw.etcd.read(mw.config.Etcd.BaseKeyToWrite + "/")
+		if err != nil {
+			return err
+		}
+		merged, conflicts := mergeConcurrentWrites(values, interpolated, known, theirs, theirRevs)
+		if len(conflicts) > 0 && !mw.keepOurValues(conflicts, merged, theirs) {
+			for _, k := range conflicts {
+				delete(merged, k)
+			}
+			if used, err := strconv.ParseFloat(string(theirs[mw.config.Etcd.BaseKeyToWrite+"/"+regValue1]), 64); err == nil {
+				mw.config.bwCurrentUsed = used
+				mw.config.bwUploadUsed, _ = strconv.ParseFloat(string(theirs[mw.config.Etcd.BaseKeyToWrite+"/"+regValue9]), 64)
+				if mw.bwTextBox != nil && !mw.bwTextBox.IsDisposed() {
+					mw.showReadings()
+				}
+			}
+		}
+		values, known = merged, theirRevs
+		mw.config.dbValues, mw.config.dbRevisions = theirs, theirRevs
+	}
+
+	return errors.New("gave up after other clients kept changing the same values")
+}
+
+// Shows the user both versions of each conflicting value and asks whether to keep ours
+func (mw *MainWin) keepOurValues(conflicts []string, ours map[string]string, theirs map[string][]byte) bool {
+	msg := "Another computer saved different values since this one last loaded:\r\n\r\n"
+	for _, k := range conflicts {
+		msg += fmt.Sprintf("%s\r\n    theirs: %s    yours: %s\r\n",
+			strings.TrimPrefix(k, mw.config.Etcd.BaseKeyToWrite+"/"), theirs[k], ours[k])
+	}
+	msg += "\r\nKeep your values? (No keeps theirs)"
+
+	var owner walk.Form
+	if mw.MainWindow != nil && !mw.IsDisposed() {
+		owner = mw
+	}
+
+	return walk.MsgBox(owner, "Conflicting update", msg, walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) == walk.DlgCmdYes
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeConcurrentWrites(t *testing.T) {
+	ours := map[string]string{
+		"/bw/bwCurrentUsed":     "700",
+		"/bw/dayOfMonth/10":     "38.100",
+		"/bw/dayOfMonth/11":     "38.200",
+		"/bw/dayOfMonth/12":     "38.300",
+		"/bw/bwMax":             "39.000",
+		"/bw/bwPerDayRemaining": "38.300",
+	}
+	interpolated := map[string]string{"/bw/dayOfMonth/10": "38.100", "/bw/dayOfMonth/11": "38.200"}
+	known := map[string]int64{"/bw/bwCurrentUsed": 5, "/bw/bwMax": 5, "/bw/bwPerDayRemaining": 5}
+
+	tests := []struct {
+		name              string
+		theirs            map[string][]byte
+		theirRevs         map[string]int64
+		expectedMerged    map[string]string
+		expectedConflicts []string
+	}{
+		{
+			"Check nothing changed by others",
+			map[string][]byte{"/bw/bwCurrentUsed": []byte("650"), "/bw/bwMax": []byte("38.000"), "/bw/bwPerDayRemaining": []byte("39.000")},
+			map[string]int64{"/bw/bwCurrentUsed": 5, "/bw/bwMax": 5, "/bw/bwPerDayRemaining": 5},
+			ours,
+			[]string{},
+		},
+		{
+			"Check their real day beats our interpolated day",
+			map[string][]byte{"/bw/dayOfMonth/10": []byte("37.000"), "/bw/bwCurrentUsed": []byte("650"),
+				"/bw/bwMax": []byte("38.000"), "/bw/bwPerDayRemaining": []byte("39.000")},
+			map[string]int64{"/bw/dayOfMonth/10": 9, "/bw/bwCurrentUsed": 5, "/bw/bwMax": 5, "/bw/bwPerDayRemaining": 5},
+			map[string]string{
+				"/bw/bwCurrentUsed":     "700",
+				"/bw/dayOfMonth/11":     "38.200",
+				"/bw/dayOfMonth/12":     "38.300",
+				"/bw/bwMax":             "39.000",
+				"/bw/bwPerDayRemaining": "38.300",
+			},
+			[]string{},
+		},
+		{
+			"Check changed reading is a conflict",
+			map[string][]byte{"/bw/bwCurrentUsed": []byte("710"), "/bw/dayOfMonth/12": []byte("37.900"),
+				"/bw/bwMax": []byte("38.000"), "/bw/bwPerDayRemaining": []byte("37.900")},
+			map[string]int64{"/bw/bwCurrentUsed": 9, "/bw/dayOfMonth/12": 9, "/bw/bwMax": 9, "/bw/bwPerDayRemaining": 9},
+			ours,
+			[]string{"/bw/bwCurrentUsed", "/bw/bwPerDayRemaining", "/bw/dayOfMonth/12"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts := mergeConcurrentWrites(ours, interpolated, known, test.theirs, test.theirRevs)
+			if !reflect.DeepEqual(merged, test.expectedMerged) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expectedMerged, merged)
+			}
+			if !reflect.DeepEqual(conflicts, test.expectedConflicts) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expectedConflicts, conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const defaultBlockSizeGB = 50
+
+// What going over the cap costs. Overage is charged per block of GB started, up to a monthly
+// maximum, or the cap can be lifted for a flat unlimited add-on price. The first few cycles
+// over the cap in any rolling year are courtesy months, which aren't charged
+type Pricing struct {
+	BlockSize      float64 `yaml:"blockSize"`      // GB per overage block, defaults to 50
+	BlockPrice     float64 `yaml:"blockPrice"`     // price of each block, no cost shown if 0
+	MaxOverage     float64 `yaml:"maxOverage"`     // most overage charged in a month, 0 for no maximum
+	UnlimitedPrice float64 `yaml:"unlimitedPrice"` // monthly price of the unlimited add-on, 0 if there isn't one
+	CourtesyMonths int     `yaml:"courtesyMonths"` // cycles over the cap not charged per rolling year
+}
+
+// Overage cost so far and by the end of the cycle at the current rate
+type costEstimate struct {
+	current, projected float64
+	projectedUsed      float64
+	unlimited          float64
+	courtesyLeft       int     // courtesy months left, this cycle would use one up if over
+	waived             float64 // projected overage a courtesy month saves
+}
+
+func (p Pricing) isSet() bool {
+	return p.BlockPrice > 0
+}
+
+// Checks the prices, where is the setting's name to use in the errors
+func (p Pricing) validate(where string) []error {
+	errs := []error{}
+	for _, setting := range []struct {
+		name  string
+		value float64
+	}{{"blockSize", p.BlockSize}, {"blockPrice", p.BlockPrice}, {"maxOverage", p.MaxOverage}, {"unlimitedPrice", p.UnlimitedPrice}} {
+		if setting.value < 0 || math.IsNaN(setting.value) {
+			errs = append(errs, fmt.Errorf("%s.%s can't be negative, got %v", where, setting.name, setting.value))
+		}
+	}
+	if p.CourtesyMonths < 0 || p.CourtesyMonths > 12 {
+		errs = append(errs, fmt.Errorf("%s.courtesyMonths must be between 0 and 12, got %d", where, p.CourtesyMonths))
+	}
+
+	return errs
+}
+
+// Returns what using that much costs on top of the plan, every block started is charged in full
+func (p Pricing) overageCost(used, capGB float64) float64 {
+	if !p.isSet() || used <= capGB {
+		return 0
+	}
+	blockSize := p.BlockSize
+	if blockSize <= 0 {
+		blockSize = defaultBlockSizeGB
+	}
+	cost := math.Ceil((used-capGB)/blockSize) * p.BlockPrice
+	if p.MaxOverage > 0 && cost > p.MaxOverage {
+		cost = p.MaxOverage
+	}
+
+	return cost
+}
+
+// Returns how much will have been used by the end of the cycle if usage carries on at the
+// same rate. Less than a day in counts as a whole day so the first hours don't blow it up
+func projectedUsage(used, daysSoFar, totalDays float64) float64 {
+	if daysSoFar < 1 {
+		daysSoFar = 1
+	}
+	if daysSoFar > totalDays {
+		return used
+	}
+
+	return used / daysSoFar * totalDays
+}
+
+// Works out the overage cost now and at the end of the cycle, nothing being charged while
+// there is a courtesy month left to cover it
+func (p Pricing) estimate(used, daysSoFar, totalDays, capGB float64, courtesyLeft int) costEstimate {
+	projected := projectedUsage(used, daysSoFar, totalDays)
+	est := costEstimate{
+		current:       p.overageCost(used, capGB),
+		projected:     p.overageCost(projected, capGB),
+		projectedUsed: projected,
+		unlimited:     p.UnlimitedPrice,
+		courtesyLeft:  courtesyLeft,
+	}
+	if courtesyLeft > 0 {
+		est.waived = est.projected
+		est.current, est.projected = 0, 0
+	}
+
+	return est
+}
+
+// Returns true if the unlimited add-on would cost less than the overage expected this cycle
+func (est costEstimate) recommendUnlimited() bool {
+	return est.unlimited > 0 && est.projected > est.unlimited
+}
+
+// Builds the cost lines shown with the calculation results
+func formatCostEstimate(est costEstimate) string {
+	output := fmt.Sprintf("Overage cost now / projected:   $%.2f / $%.2f    (Projected use: %.0f GB)\r\n",
+		est.current, est.projected, est.projectedUsed)
+	switch {
+	case est.waived > 0:
+		output += fmt.Sprintf("Recommendation:                 no charge, a courtesy month covers $%.2f (%d left this year)\r\n",
+			est.waived, est.courtesyLeft)
+	case est.projected == 0:
+		output += "Recommendation:                 no overage expected at this rate\r\n"
+	case est.recommendUnlimited():
+		output += fmt.Sprintf("Recommendation:                 buy unlimited ($%.2f), cheaper than the projected overage\r\n", est.unlimited)
+	case est.unlimited > 0:
+		output += fmt.Sprintf("Recommendation:                 pay the overage, unlimited costs $%.2f\r\n", est.unlimited)
+	}
+
+	return output
+}
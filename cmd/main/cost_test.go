@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverageCost(t *testing.T) {
+	pricing := Pricing{BlockSize: 50, BlockPrice: 10, MaxOverage: 100, UnlimitedPrice: 30}
+
+	tests := []struct {
+		name     string
+		used     float64
+		expected float64
+	}{
+		{"Check under cap costs nothing", 1200, 0},
+		{"Check part of a block is charged in full", 1230, 10},
+		{"Check exactly one block", 1279, 10},
+		{"Check second block", 1280, 20},
+		{"Check overage stops at the maximum", 2500, 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if cost := pricing.overageCost(test.used, 1229); cost != test.expected {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, cost)
+			}
+		})
+	}
+}
+
+func TestCostEstimate(t *testing.T) {
+	pricing := Pricing{BlockSize: 50, BlockPrice: 10, MaxOverage: 100, UnlimitedPrice: 30}
+
+	tests := []struct {
+		name      string
+		used      float64
+		daysSoFar float64
+		courtesy  int
+		expected  costEstimate
+		expAdvice string
+	}{
+		{"Check on track", 600, 15, 0, costEstimate{0, 0, 1200, 30, 0, 0}, "no overage expected"},
+		{"Check small overage is cheaper", 620, 15, 0, costEstimate{0, 10, 1240, 30, 0, 0}, "pay the overage"},
+		{"Check big overage means buying unlimited", 900, 15, 0, costEstimate{0, 100, 1800, 30, 0, 0}, "buy unlimited"},
+		{"Check courtesy month waives the overage", 900, 15, 1, costEstimate{0, 0, 1800, 30, 1, 100}, "a courtesy month covers $100.00"},
+		{"Check first hours count as a day", 50, 0.25, 0, costEstimate{0, 60, 1500, 30, 0, 0}, "buy unlimited"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			est := pricing.estimate(test.used, test.daysSoFar, 30, 1229, test.courtesy)
+			if est != test.expected {
+				t.Errorf("ERROR: Expected: %+v got: %+v", test.expected, est)
+			}
+			if advice := formatCostEstimate(est); !strings.Contains(advice, test.expAdvice) {
+				t.Errorf("ERROR: Expected: %q got: %q", test.expAdvice, advice)
+			}
+		})
+	}
+}
+
+func TestValidatePricing(t *testing.T) {
+	if errs := (Pricing{BlockSize: 50, BlockPrice: -10, MaxOverage: -1}).validate("pricing"); len(errs) != 2 {
+		t.Errorf("ERROR: Expected: 2 errors got: %v", errs)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Plans let a few cycles a year go over the cap without charge. Which cycles went over is
+// worked out from the archived history, so nothing extra has to be stored
+
+// How worried to be about this cycle's usage, worst last
+type severity int
+
+const (
+	severityOK       severity = iota // on track to stay under the cap
+	severityCourtesy                 // over or heading over, but a courtesy month covers it
+	severityWarning                  // heading over the cap and would be charged
+	severityCritical                 // over the cap and being charged
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityCourtesy:
+		return "over the cap, covered by a courtesy month"
+	case severityWarning:
+		return "heading over the cap"
+	case severityCritical:
+		return "over the cap, overage being charged"
+	default:
+		return "on track"
+	}
+}
+
+// Returns the archived cycles (as YYYY-MM) whose metered usage went over the cap, plus whatever
+// was banked for them, oldest first. Archived cycles are measured against today's cap and what
+// it counts
+func monthsOverCap(values map[string][]byte, baseKey string, capGB float64, capCounts string) []string {
+	historySubkey := baseKey + "/" + regValue7 + "/"
+	over := []string{}
+	for k, v := range values {
+		if !strings.HasPrefix(k, historySubkey) || !strings.HasSuffix(k, "/"+regValue1) {
+			continue
+		}
+		month := strings.TrimSuffix(k[len(historySubkey):], "/"+regValue1)
+		used, err := strconv.ParseFloat(string(v), 64)
+		if err != nil || strings.Contains(month, "/") {
+			continue
+		}
+		upload, _ := strconv.ParseFloat(string(values[historySubkey+month+"/"+regValue9]), 64)
+		unmetered, _ := strconv.ParseFloat(string(values[historySubkey+month+"/"+regValue12]), 64)
+		banked, _ := strconv.ParseFloat(string(values[historySubkey+month+"/"+regValue13]), 64)
+		if meteredUsage(countedUsage(used, upload, capCounts), unmetered) > capGB+banked {
+			over = append(over, month)
+		}
+	}
+	sort.Strings(over)
+
+	return over
+}
+
+// Returns how many courtesy months are left for the cycle starting at start, the cycles over
+// the cap in the year before it each having used one up
+func courtesyMonthsLeft(overCap []string, allowed int, start time.Time) int {
+	from := start.AddDate(-1, 0, 0).Format("2006-01")
+	to := start.Format("2006-01")
+	left := allowed
+	for _, month := range overCap {
+		if month >= from && month < to {
+			left--
+		}
+	}
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+// Works out how worried to be, given what's used now and by the end of the cycle
+func usageSeverity(used, projected, capGB float64, courtesyLeft int) severity {
+	switch {
+	case used <= capGB && projected <= capGB:
+		return severityOK
+	case courtesyLeft > 0:
+		return severityCourtesy
+	case used <= capGB:
+		return severityWarning
+	default:
+		return severityCritical
+	}
+}
+
+// Returns the courtesy months left for the cycle in use, from the history of the profile in use
+func (mw *MainWin) courtesyLeft(start time.Time) int {
+	overCap := monthsOverCap(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite, mw.config.Cap, mw.config.CapCounts)
+
+	return courtesyMonthsLeft(overCap, mw.config.Pricing.CourtesyMonths, start)
+}
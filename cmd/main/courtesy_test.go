@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCourtesyMonths(t *testing.T) {
+	values := map[string][]byte{
+		"/bw/history/2023-02/bwCurrentUsed": []byte("1300"),
+		"/bw/history/2023-05/bwCurrentUsed": []byte("1250"),
+		"/bw/history/2023-05/unmetered":     []byte("40"),
+		"/bw/history/2023-09/bwCurrentUsed": []byte("1400"),
+		"/bw/history/2023-09/bwUploadUsed":  []byte("200"),
+		"/bw/history/2024-01/bwCurrentUsed": []byte("1240"),
+		"/bw/history/2024-02/bwCurrentUsed": []byte("900"),
+		"/bw/history/2024-02/05":            []byte("30.000"),
+	}
+
+	over := monthsOverCap(values, "/bw", 1229, capCountsBoth)
+	if expected := []string{"2023-02", "2023-09", "2024-01"}; !reflect.DeepEqual(over, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, over)
+	}
+	over = monthsOverCap(values, "/bw", 1229, capCountsDownload)
+	if expected := []string{"2023-02", "2024-01"}; !reflect.DeepEqual(over, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, over)
+	}
+
+	tests := []struct {
+		name     string
+		start    time.Time
+		allowed  int
+		expected int
+	}{
+		{"Check months over a year ago don't count", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), 3, 1},
+		{"Check the month a year back counts", time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local), 3, 0},
+		{"Check none left doesn't go negative", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), 1, 0},
+		{"Check months after the cycle don't count", time.Date(2023, 9, 1, 0, 0, 0, 0, time.Local), 1, 0},
+		{"Check no courtesy months", time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local), 0, 0},
+	}
+
+	all := monthsOverCap(values, "/bw", 1229, capCountsBoth)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if left := courtesyMonthsLeft(all, test.allowed, test.start); left != test.expected {
+				t.Errorf("ERROR: Expected: %d got: %d", test.expected, left)
+			}
+		})
+	}
+}
+
+func TestUsageSeverity(t *testing.T) {
+	tests := []struct {
+		name         string
+		used         float64
+		projected    float64
+		courtesyLeft int
+		expected     severity
+	}{
+		{"Check on track", 500, 1000, 0, severityOK},
+		{"Check heading over", 800, 1500, 0, severityWarning},
+		{"Check over and charged", 1300, 1500, 0, severityCritical},
+		{"Check courtesy month covers it", 1300, 1500, 1, severityCourtesy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if level := usageSeverity(test.used, test.projected, 1229, test.courtesyLeft); level != test.expected {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, level)
+			}
+		})
+	}
+}
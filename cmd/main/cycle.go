@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Billing cycles start on the same day every month, a start day of 1 being calendar months.
+// Days are still stored by day of month, which are unique within a cycle, and the cycle is
+// known by the month it started in
+
+// Returns the midnight the billing cycle containing now started on
+func cycleStart(now time.Time, startDay int) time.Time {
+	if startDay < 1 {
+		startDay = 1
+	}
+	start := time.Date(now.Year(), now.Month(), startDay, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+
+	return start
+}
+
+// Returns how many days long the cycle starting at start is
+func cycleDays(start time.Time) float64 {
+	return math.Round(start.AddDate(0, 1, 0).Sub(start).Hours() / 24)
+}
+
+// Returns the date a day of month falls on within the cycle starting at start
+func cycleDate(start time.Time, day int) time.Time {
+	month := start.Month()
+	if day < start.Day() { // wrapped into the month after the cycle started
+		month++
+	}
+
+	return time.Date(start.Year(), month, day, 0, 0, 0, 0, start.Location())
+}
+
+// Returns the YYYY-MM of the cycle that started in month, the last one before the cycle
+// containing now. Empty if month isn't a month
+func finishedMonth(month int64, now time.Time, startDay int) string {
+	if month < 1 || month > 12 {
+		return ""
+	}
+	start := cycleStart(now, startDay)
+	year := start.Year()
+	if month > int64(start.Month()) { // the finished month must have been last year
+		year--
+	}
+
+	return fmt.Sprintf("%d-%02d", year, month)
+}
+
+// Returns the days of the month in the order they come in a cycle starting on startDay
+func cycleDayOrder(startDay int) []int {
+	if startDay < 1 {
+		startDay = 1
+	}
+	days := []int{}
+	for i := 0; i < 31; i++ {
+		days = append(days, (startDay-1+i)%31+1)
+	}
+
+	return days
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCycleStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		now      time.Time
+		startDay int
+		expected time.Time
+		expDays  float64
+	}{
+		{"Check calendar month", time.Date(2024, 2, 10, 15, 0, 0, 0, time.UTC), 1, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), 29},
+		{"Check after the start day", time.Date(2024, 2, 20, 15, 0, 0, 0, time.UTC), 15, time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC), 29},
+		{"Check before the start day", time.Date(2024, 2, 10, 15, 0, 0, 0, time.UTC), 15, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), 31},
+		{"Check before the start day in January", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), 28, time.Date(2023, 12, 28, 0, 0, 0, 0, time.UTC), 31},
+		{"Check start day not set", time.Date(2023, 6, 30, 23, 0, 0, 0, time.UTC), 0, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), 30},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := cycleStart(test.now, test.startDay)
+			if !start.Equal(test.expected) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, start)
+			}
+			if days := cycleDays(start); days != test.expDays {
+				t.Errorf("ERROR: Expected: %v days got: %v", test.expDays, days)
+			}
+		})
+	}
+}
+
+func TestCycleDate(t *testing.T) {
+	start := time.Date(2023, 12, 15, 0, 0, 0, 0, time.UTC)
+	if date := cycleDate(start, 20); !date.Equal(time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ERROR: Expected: 2023-12-20 got: %v", date)
+	}
+	if date := cycleDate(start, 3); !date.Equal(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ERROR: Expected: 2024-01-03 got: %v", date)
+	}
+}
+
+func TestCycleDayOrder(t *testing.T) {
+	days := cycleDayOrder(25)
+	if len(days) != 31 || !reflect.DeepEqual(days[:8], []int{25, 26, 27, 28, 29, 30, 31, 1}) || days[30] != 24 {
+		t.Errorf("ERROR: Expected: 25 to 31 then 1 to 24 got: %v", days)
+	}
+	if days = cycleDayOrder(1); days[0] != 1 || days[30] != 31 {
+		t.Errorf("ERROR: Expected: 1 to 31 got: %v", days)
+	}
+}
+
+func TestFinishedMonth(t *testing.T) {
+	now := time.Date(2024, 1, 20, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		month    int64
+		startDay int
+		expected string
+	}{
+		{12, 1, "2023-12"},
+		{1, 25, "2023-01"}, // the cycle in use started on 2023-12-25, so January's was a year before
+		{11, 15, "2023-11"},
+		{0, 1, ""},
+	}
+
+	for _, test := range tests {
+		if month := finishedMonth(test.month, now, test.startDay); month != test.expected {
+			t.Errorf("ERROR: Expected: %q got: %q", test.expected, month)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+	"go.uber.org/zap"
+)
+
+// The day editor lists every day of the cycle so far, any of which can be set, deleted or
+// worked out again from the days around it. Each edit goes through the audit trail, and the
+// ids of the entries it made are kept so edits can be undone one after another, newest first
+
+const (
+	auditEditDay        = "edit day"
+	auditInterpolateDay = "re-interpolate day"
+)
+
+// One day of the cycle so far
+type editorDay struct {
+	day      string // DD, as it is stored
+	date     time.Time
+	value    float64
+	recorded bool
+}
+
+// Returns every day from the start of the cycle up to today, with what is recorded for each
+func cycleEditorDays(series map[string]float64, start, now time.Time) []editorDay {
+	days := []editorDay{}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for date := start; !date.After(today); date = date.AddDate(0, 0, 1) {
+		day := editorDay{day: getStrDayOfMonth(date.Day()), date: date}
+		day.value, day.recorded = series[day.day]
+		days = append(days, day)
+	}
+
+	return days
+}
+
+// Works out a day's value on a straight line between the nearest recorded days either side of
+// it, the same way missing days are filled in. With days recorded on one side only the nearest
+// is copied. False if no other day is recorded
+func interpolateDay(days []editorDay, index int) (float64, bool) {
+	before, after := -1, -1
+	for i := index - 1; i >= 0 && before < 0; i-- {
+		if days[i].recorded {
+			before = i
+		}
+	}
+	for i := index + 1; i < len(days) && after < 0; i++ {
+		if days[i].recorded {
+			after = i
+		}
+	}
+
+	switch {
+	case before >= 0 && after >= 0:
+		step := (days[after].value - days[before].value) / float64(after-before)
+		return days[before].value + step*float64(index-before), true
+	case before >= 0:
+		return days[before].value, true
+	case after >= 0:
+		return days[after].value, true
+	default:
+		return 0, false
+	}
+}
+
+// Builds the line the day is listed with
+func formatEditorDay(day editorDay) string {
+	if !day.recorded {
+		return fmt.Sprintf("%s   %12s", day.date.Format("Mon 02 Jan"), "(none)")
+	}
+
+	return fmt.Sprintf("%s   %9.3f GB", day.date.Format("Mon 02 Jan"), day.value)
+}
+
+// Returns the keys stored for a day, its GB per day remaining and the running totals at its end
+func dayKeys(baseKey, day string) []string {
+	return []string{
+		baseKey + "/" + regValue3 + "/" + day,
+		baseKey + "/" + regValue10 + "/" + day,
+		baseKey + "/" + regValue11 + "/" + day,
+	}
+}
+
+// Writes and deletes days along with an audit entry, as long as nobody changed them since they
+// were read. Returns the entry's id, empty if nothing changed
+func (mw *MainWin) commitDayEdit(op string, puts map[string]string, deletes ...string) (string, error) {
+	current, revisions := map[string][]byte{}, map[string]int64{}
+	for _, regValue := range []string{regValue3, regValue10, regValue11} {
+		subkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue + "/"
+		values, revs, err := mw.etcd.read(subkey)
+		if err != nil {
+			return "", &storeError{Store: "etcd", Op: "read", Key: subkey, Err: err}
+		}
+		for k, v := range values {
+			current[k], revisions[k] = v, revs[k]
+		}
+	}
+	key, value, ok := mw.auditPut(op, auditChanges(current, puts, deletes, nil))
+	if !ok {
+		return "", nil
+	}
+
+	audited := map[string]string{key: value}
+	expected := map[string]int64{}
+	for k, v := range puts {
+		audited[k] = v
+		expected[k] = revisions[k]
+	}
+	for _, k := range deletes {
+		expected[k] = revisions[k]
+	}
+	ok, err := mw.etcd.commitIf(audited, expected, deletes)
+	if err != nil {
+		return "", err
+	} else if !ok {
+		return "", errors.New("another client changed the same day, try again")
+	}
+	zap.L().Info("edited days", zap.String("op", op), zap.Strings("puts", sortedStringKeys(puts)), zap.Strings("deletes", deletes))
+
+	return strings.TrimPrefix(key, mw.auditPrefix()), nil
+}
+
+// Opens a window listing every day of the cycle so far, where any day can be edited, deleted
+// or re-interpolated, and those edits undone
+func (mw *MainWin) showDayEditor() {
+	if !mw.useEtcd {
+		walk.MsgBox(mw, "Info", "Daily values are only kept in etcd", walk.MsgBoxIconInformation)
+		return
+	}
+
+	var dlg *walk.Dialog
+	var dayList *walk.ListBox
+	var valueEdit *walk.NumberEdit
+	var undoButton *walk.PushButton
+	var days []editorDay
+	dayOfMonthSubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue3
+
+	refresh := func() {
+		selected := dayList.CurrentIndex()
+		values, _, err := mw.etcd.read(dayOfMonthSubkey + "/")
+		if err != nil {
+			mw.showError("Could not read the days", err)
+		}
+		days = cycleEditorDays(getDaySeries(values, dayOfMonthSubkey), cycleStart(time.Now(), mw.config.CycleStartDay), time.Now())
+		items := []string{}
+		for _, day := range days {
+			items = append(items, formatEditorDay(day))
+		}
+		dayList.SetModel(items)
+		if selected < 0 || selected >= len(days) {
+			selected = len(days) - 1
+		}
+		dayList.SetCurrentIndex(selected)
+		undoButton.SetEnabled(len(mw.dayEdits) > 0)
+	}
+	edit := func(op string, change func(day editorDay, index int) (map[string]string, []string, error)) {
+		index := dayList.CurrentIndex()
+		if index < 0 || index >= len(days) {
+			return
+		}
+		puts, deletes, err := change(days[index], index)
+		if err == nil {
+			var id string
+			if id, err = mw.commitDayEdit(op, puts, deletes...); id != "" {
+				mw.dayEdits = append(mw.dayEdits, id)
+			}
+		}
+		if err != nil {
+			mw.showError("Day not changed", err)
+		}
+		refresh()
+	}
+	undo := func() {
+		if len(mw.dayEdits) == 0 {
+			return
+		}
+		id := mw.dayEdits[len(mw.dayEdits)-1]
+		err := mw.undoAudit(id, false)
+		if undoNeedsForce(err) &&
+			walk.MsgBox(dlg, "Undo", err.Error()+"\r\n\r\nUndo anyway?", walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) == walk.DlgCmdYes {
+			err = mw.undoAudit(id, true)
+		}
+		if err == nil {
+			mw.dayEdits = mw.dayEdits[:len(mw.dayEdits)-1]
+		} else if !undoNeedsForce(err) {
+			mw.showError("Edit not undone", err)
+		}
+		refresh()
+	}
+
+	Dialog{
+		AssignTo: &dlg,
+		Title:    "Edit days",
+		MinSize:  Size{500, initialWinHeight - 300},
+		Layout:   VBox{},
+		Children: []Widget{
+			ListBox{
+				AssignTo: &dayList,
+				MinSize:  Size{450, 400},
+				Font: Font{
+					Family:    "Courier New",
+					PointSize: 10,
+				},
+				OnCurrentIndexChanged: func() {
+					if index := dayList.CurrentIndex(); index >= 0 && index < len(days) {
+						valueEdit.SetValue(days[index].value)
+					}
+				},
+			},
+			Composite{
+				Layout: HBox{
+					MarginsZero: true,
+				},
+				Children: []Widget{
+					Label{
+						Text: "GB per day remaining:",
+					},
+					NumberEdit{
+						AssignTo: &valueEdit,
+						Decimals: 3,
+						MinValue: -100000,
+						MaxValue: 100000,
+					},
+					PushButton{
+						Text: "   Set   ",
+						OnClicked: func() {
+							edit(auditEditDay, func(day editorDay, index int) (map[string]string, []string, error) {
+								return map[string]string{dayOfMonthSubkey + "/" + day.day: fmt.Sprintf("%.3f", valueEdit.Value())}, nil, nil
+							})
+						},
+					},
+					PushButton{
+						Text:        "   Delete   ",
+						ToolTipText: "Deletes the day's GB per day remaining and the running totals recorded for it",
+						OnClicked: func() {
+							edit(auditDeleteDay, func(day editorDay, index int) (map[string]string, []string, error) {
+								return nil, dayKeys(mw.config.Etcd.BaseKeyToWrite, day.day), nil
+							})
+						},
+					},
+					PushButton{
+						Text: "   Re-interpolate   ",
+						OnClicked: func() {
+							edit(auditInterpolateDay, func(day editorDay, index int) (map[string]string, []string, error) {
+								value, ok := interpolateDay(days, index)
+								if !ok {
+									return nil, nil, errors.New("no other day recorded to work it out from")
+								}
+								return map[string]string{dayOfMonthSubkey + "/" + day.day: fmt.Sprintf("%.3f", value)}, nil, nil
+							})
+						},
+					},
+				},
+			},
+			Composite{
+				Layout: HBox{
+					MarginsZero: true,
+				},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &undoButton,
+						Text:     "   Undo   ",
+						OnClicked: func() {
+							undo()
+						},
+					},
+					PushButton{
+						Text: "   Close   ",
+						OnClicked: func() {
+							dlg.Accept()
+						},
+					},
+				},
+			},
+		},
+	}.Create(mw)
+
+	refresh()
+	dlg.Run()
+
+	mw.redrawChart()
+	mw.showResults()
+}
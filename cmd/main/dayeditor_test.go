@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCycleEditorDays(t *testing.T) {
+	series := map[string]float64{"15": 40, "16": 39.5, "18": 38}
+	start := time.Date(2023, 1, 15, 0, 0, 0, 0, time.Local)
+	days := cycleEditorDays(series, start, time.Date(2023, 2, 2, 18, 30, 0, 0, time.Local))
+
+	// 15 Jan to 2 Feb, wrapping into the next month
+	if len(days) != 19 || days[0].day != "15" || days[18].day != "02" || !days[18].date.Equal(time.Date(2023, 2, 2, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("ERROR: Expected: 19 days from 15 to 02 got: %v", days)
+	}
+	if !days[1].recorded || days[1].value != 39.5 || days[2].recorded {
+		t.Errorf("ERROR: Expected: 16 recorded and 17 not got: %v, %v", days[1], days[2])
+	}
+}
+
+func TestInterpolateDay(t *testing.T) {
+	days := []editorDay{
+		{day: "01", value: 40, recorded: true},
+		{day: "02"},
+		{day: "03", value: 99, recorded: true},
+		{day: "04", value: 37, recorded: true},
+		{day: "05"},
+	}
+	tests := []struct {
+		name     string
+		days     []editorDay
+		index    int
+		expected float64
+		ok       bool
+	}{
+		{"Check between recorded days", days, 1, 69.5, true},
+		{"Check recorded day worked out again", days, 2, 38, true},
+		{"Check last day copies the one before", days, 4, 37, true},
+		{"Check first day copies the one after", days[1:], 0, 99, true},
+		{"Check nothing else recorded", []editorDay{{day: "01"}, {day: "02", value: 40, recorded: true}}, 1, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := interpolateDay(test.days, test.index)
+			if ok != test.ok || math.Abs(value-test.expected) > 0.0001 {
+				t.Errorf("ERROR: Expected: %v, %v got: %v, %v", test.expected, test.ok, value, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lxn/walk"
+	"go.uber.org/zap"
+)
+
+const (
+	appTitle              = "Bandwidth Calculator"
+	degradedRetryInterval = time.Minute
+)
+
+// Switches to degraded mode after etcd stopped answering mid session. Readings get queued
+// to the local journal and we keep checking for etcd so they can be replayed. Etcd isn't used
+// again until it answers, so saves go straight to the journal rather than each waiting out
+// the timeout first
+func (mw *MainWin) enterDegraded(err error) {
+	if !mw.degraded {
+		zap.L().Warn("etcd unreachable, queueing readings locally", zap.Error(err))
+	}
+	mw.degraded = true
+	mw.useEtcd = false
+	mw.etcd.close()
+	mw.etcd = nil
+	markUnhealthy(mw.health, err)
+	mw.updateStatus()
+
+	if mw.retryTicker != nil {
+		return
+	}
+	mw.retryTicker = time.NewTicker(degradedRetryInterval)
+	go func(ticker *time.Ticker) {
+		for range ticker.C {
+			if mw.MainWindow == nil {
+				continue
+			}
+			// the config belongs to the gui thread, so copy it there and probe from here with
+			// the copy, only bothering the gui thread again once something is answering. The
+			// results are handed over so reconnecting doesn't probe again
+			mw.Synchronize(func() {
+				cfg, timeout := mw.config.Etcd, mw.etcdTimeout()
+				cfg.Endpoints = append([]string{}, cfg.Endpoints...)
+				go func() {
+					if health := probeConfigured(cfg, timeout); len(healthyEndpoints(health)) > 0 {
+						mw.Synchronize(func() {
+							mw.health = health
+							mw.tryLeaveDegraded()
+						})
+					}
+				}()
+			})
+		}
+	}(mw.retryTicker)
+}
+
+// Reloads from etcd, which replays the journal and leaves degraded mode if it worked
+func (mw *MainWin) tryLeaveDegraded() {
+	if !mw.degraded || mw.IsDisposed() {
+		return
+	}
+	mw.refreshFromDB()
+}
+
+func (mw *MainWin) leaveDegraded() {
+	mw.degraded = false
+	if mw.retryTicker != nil {
+		mw.retryTicker.Stop()
+		mw.retryTicker = nil
+	}
+	mw.updateStatus()
+}
+
+// Probes every endpoint in the settings, none if the certs can't be loaded
+func probeConfigured(cfg EtcdConfig, timeout time.Duration) []endpointHealth {
+	clientConfig, err := etcdClientConfig(cfg, timeout)
+	if err != nil {
+		return nil
+	}
+
+	return probeEndpoints(cfg.Endpoints, clientConfig)
+}
+
+// Shows in the title bar and status indicator which store we are using, which etcd member
+// served the data, or how much is waiting to be sent when we are running without etcd
+func (mw *MainWin) updateStatus() {
+	if mw.MainWindow == nil || mw.IsDisposed() {
+		return
+	}
+	title := appTitle
+	if mw.profile != "" {
+		title += " - " + mw.profile
+	}
+	status := "Storage: registry"
+	if mw.useEtcd && mw.etcd != nil {
+		endpoint := endpointForMember(mw.health, mw.etcd.servedBy)
+		status = fmt.Sprintf("Storage: etcd %s (member %x)", endpoint, mw.etcd.servedBy)
+	} else if mw.degraded || mw.etcdConfigured() {
+		entries, _ := readJournal(mw.journalPath())
+		title += fmt.Sprintf("  (offline, %d readings queued)", len(entries))
+		status = fmt.Sprintf("Storage: offline, %d readings queued", len(entries))
+	}
+	mw.SetTitle(title)
+
+	if mw.statusLabel != nil {
+		mw.statusLabel.SetText(status)
+		mw.statusLabel.SetToolTipText(formatHealth(mw.health))
+	}
+}
+
+// Tells the user about a storage or chart error without exiting the program
+func (mw *MainWin) showError(what string, err error) {
+	var owner walk.Form
+	if mw.MainWindow != nil && !mw.IsDisposed() {
+		owner = mw
+	}
+	icon := walk.MsgBoxIconError
+	if isUnreachable(err) {
+		icon = walk.MsgBoxIconWarning
+	}
+	zap.L().Error(what, zap.Error(err))
+	walk.MsgBox(owner, "Error", what+": "+err.Error(), icon)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+	"github.com/wcharczuk/go-chart"
+)
+
+// Per device usage is kept under the profile as devices/<device>/DD, each value being the GB
+// that device used that day. Collectors write these directly, or they can be entered by hand
+
+// GB a device used today and over the cycle so far
+type deviceUsage struct {
+	name         string
+	today, total float64
+}
+
+// Returns each device's daily series (device to day to GB) from the values under the base key
+func getDeviceSeries(values map[string][]byte, baseKey string) map[string]map[string]float64 {
+	series := map[string]map[string]float64{}
+	devicesSubkey := baseKey + "/" + regValue8 + "/"
+	for k, v := range values {
+		if !strings.HasPrefix(k, devicesSubkey) {
+			continue
+		}
+		parts := strings.Split(k[len(devicesSubkey):], "/")
+		if len(parts) != 2 || parts[0] == "" || !isValidDayKey(parts[1]) {
+			continue
+		}
+		gb, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			continue
+		}
+		if series[parts[0]] == nil {
+			series[parts[0]] = map[string]float64{}
+		}
+		series[parts[0]][parts[1]] = gb
+	}
+
+	return series
+}
+
+// Totals up each device for today and the cycle, biggest user first
+func summarizeDevices(series map[string]map[string]float64, today string) []deviceUsage {
+	usages := []deviceUsage{}
+	for device, days := range series {
+		usage := deviceUsage{name: device, today: days[today]}
+		for _, gb := range days {
+			usage.total += gb
+		}
+		usages = append(usages, usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].total != usages[j].total {
+			return usages[i].total > usages[j].total
+		}
+		return usages[i].name < usages[j].name
+	})
+
+	return usages
+}
+
+// Builds the per device table shown under the calculation results
+func formatDeviceTable(usages []deviceUsage) string {
+	cycleTotal := 0.0
+	for _, usage := range usages {
+		cycleTotal += usage.total
+	}
+
+	output := fmt.Sprintf("%-20s %10s %12s %7s\r\n", "Device", "Today (GB)", "Cycle (GB)", "Share")
+	for _, usage := range usages {
+		share := 0.0
+		if cycleTotal > 0 {
+			share = usage.total / cycleTotal * 100
+		}
+		output += fmt.Sprintf("%-20s %10.2f %12.2f %6.1f%%\r\n", usage.name, usage.today, usage.total, share)
+	}
+
+	return output
+}
+
+// Turns the device series into one stacked bar per day, in the order the billing cycle runs
+func deviceStackedBars(series map[string]map[string]float64, startDay int) []chart.StackedBar {
+	devices := []string{}
+	for device := range series {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices) // so each device keeps its colour from day to day
+
+	bars := []chart.StackedBar{}
+	for _, day := range cycleDayOrder(startDay) {
+		strDay := getStrDayOfMonth(day)
+		bar := chart.StackedBar{Name: strDay, Width: 20}
+		for _, device := range devices {
+			if gb := series[device][strDay]; gb > 0 {
+				bar.Values = append(bar.Values, chart.Value{Label: device, Value: gb})
+			}
+		}
+		if len(bar.Values) > 0 {
+			bars = append(bars, bar)
+		}
+	}
+
+	return bars
+}
+
+// Renders stacked bars (per device or per direction) into the chart file in place of the usual bars
+func renderStackedChart(bars []chart.StackedBar) error {
+	graph := chart.StackedBarChart{
+		Background: chart.Style{
+			Padding: chart.Box{
+				Top:    10,
+				Bottom: 23,
+				Right:  10,
+			},
+		},
+		DPI:    1200,
+		Width:  initialWinWidth + 75,
+		Height: graphImgHeight + 15,
+		XAxis: chart.Style{
+			Show:     true,
+			FontSize: 1.2,
+		},
+		YAxis: chart.Style{
+			Show:     true,
+			FontSize: 1.2,
+		},
+		Bars: bars,
+	}
+
+	file, err := os.OpenFile(graphFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return &storeError{Store: "chart", Op: "open", Key: graphFilename, Err: err}
+	}
+	defer file.Close()
+
+	if err = graph.Render(chart.PNG, file); err != nil {
+		return &storeError{Store: "chart", Op: "render", Key: graphFilename, Err: err}
+	}
+
+	return nil
+}
+
+// Adds GB the device used at the given time (this cycle) to that day. Usage the plan doesn't
+// meter, by time or by category, is added to the day's unmetered total as well so it is taken
+// off before the cap sees it. Returns true if it was unmetered
+func (mw *MainWin) recordDeviceUsage(device, category string, gb float64, at time.Time) (bool, error) {
+	slug := keySlug(device)
+	if slug == "" {
+		return false, errors.New("enter a device name")
+	}
+	if !mw.useEtcd && !mw.etcdConfigured() {
+		return false, errors.New("per device usage is only kept in etcd")
+	}
+	if at.Before(cycleStart(time.Now(), mw.config.CycleStartDay)) || at.After(time.Now()) {
+		return false, errors.New("only usage from the current billing cycle can be recorded")
+	}
+	strDay := getStrDayOfMonth(at.Day())
+	adds := map[string]float64{mw.config.Etcd.BaseKeyToWrite + "/" + regValue8 + "/" + slug + "/" + strDay: gb}
+	unmetered := mw.config.Unmetered.isUnmetered(at, category)
+	if unmetered {
+		adds[mw.config.Etcd.BaseKeyToWrite+"/"+regValue12+"/"+strDay] = gb
+	}
+
+	return unmetered, mw.addToKeys(adds)
+}
+
+// Adds to the values of the keys all together. Goes straight to etcd (checking nobody else
+// added to the same keys in between), or into the journal while etcd is unreachable
+func (mw *MainWin) addToKeys(adds map[string]float64) error {
+	if mw.useEtcd {
+		for attempt := 1; attempt <= maxConflictRetries; attempt++ {
+			puts, read, expected, err := mw.readAdded(adds)
+			if isUnreachable(err) {
+				mw.enterDegraded(err)
+				break
+			} else if err != nil {
+				return err
+			}
+			ok, err := mw.etcd.commitIf(mw.withAudit(auditDeviceUsage, read, puts), expected, nil)
+			if isUnreachable(err) {
+				mw.enterDegraded(err)
+				break
+			} else if err != nil || ok {
+				return err
+			}
+		}
+		if !mw.degraded {
+			return errors.New("gave up after other clients kept changing the same keys")
+		}
+	}
+
+	// offline, so add to what we last knew (including anything already queued)
+	if mw.config.dbValues == nil {
+		mw.config.dbValues = map[string][]byte{}
+	}
+	values := map[string]string{}
+	for key, gb := range adds {
+		current, _ := strconv.ParseFloat(string(mw.config.dbValues[key]), 64)
+		values[key] = fmt.Sprintf("%.3f", current+gb)
+	}
+	if err := mw.queueValues(values, nil); err != nil {
+		return err
+	}
+	for key, value := range values {
+		mw.config.dbValues[key] = []byte(value)
+	}
+
+	return nil
+}
+
+// Reads the keys from etcd and returns what to write to each with the amount added, along
+// with the values and revisions they were read at
+func (mw *MainWin) readAdded(adds map[string]float64) (map[string]string, map[string][]byte, map[string]int64, error) {
+	puts := map[string]string{}
+	read := map[string][]byte{}
+	expected := map[string]int64{}
+	for key, gb := range adds {
+		values, revisions, err := mw.etcd.read(key)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		current, _ := strconv.ParseFloat(string(values[key]), 64)
+		puts[key] = fmt.Sprintf("%.3f", current+gb)
+		if value, ok := values[key]; ok {
+			read[key] = value
+		}
+		expected[key] = revisions[key]
+	}
+
+	return puts, read, expected, nil
+}
+
+// Returns the per device table for the result panel, or nothing if no device has been recorded
+func (mw *MainWin) deviceTable() string {
+	series := getDeviceSeries(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite)
+	if len(series) == 0 {
+		return ""
+	}
+
+	return formatDeviceTable(summarizeDevices(series, getStrDayOfMonth(time.Now().Day())))
+}
+
+// Asks for a device, how much it used and when, then records it
+func (mw *MainWin) showDeviceDialog() {
+	var dlg *walk.Dialog
+	var deviceBox, categoryBox *walk.ComboBox
+	var gbEdit *walk.NumberEdit
+	var atEdit *walk.DateEdit
+	var okButton, cancelButton *walk.PushButton
+
+	devices := []string{}
+	for device := range getDeviceSeries(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite) {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	categories := append([]string{""}, mw.config.Unmetered.Categories...)
+
+	result, _ := Dialog{
+		AssignTo:      &dlg,
+		Title:         "Record device usage",
+		DefaultButton: &okButton,
+		CancelButton:  &cancelButton,
+		MinSize:       Size{350, 220},
+		Layout:        Grid{Columns: 2},
+		Children: []Widget{
+			Label{
+				Text: "Device:",
+			},
+			ComboBox{
+				AssignTo: &deviceBox,
+				Editable: true,
+				Model:    devices,
+			},
+			Label{
+				Text: "GB to add:",
+			},
+			NumberEdit{
+				AssignTo: &gbEdit,
+				Decimals: 3,
+				MinValue: 0,
+				MaxValue: 100000,
+			},
+			Label{
+				Text: "Used at:",
+			},
+			DateEdit{
+				AssignTo: &atEdit,
+				Format:   "yyyy-MM-dd HH:mm",
+				Date:     time.Now(),
+			},
+			Label{
+				Text: "Zero-rated category:",
+			},
+			ComboBox{
+				AssignTo: &categoryBox,
+				Model:    categories,
+			},
+			PushButton{
+				AssignTo: &okButton,
+				Text:     "OK",
+				OnClicked: func() {
+					if _, err := mw.recordDeviceUsage(deviceBox.Text(), categoryBox.Text(), gbEdit.Value(), atEdit.Date()); err != nil {
+						mw.showError("Device usage not saved", err)
+						return
+					}
+					dlg.Accept()
+				},
+			},
+			PushButton{
+				AssignTo: &cancelButton,
+				Text:     "Cancel",
+				OnClicked: func() {
+					dlg.Cancel()
+				},
+			},
+		},
+	}.Run(mw)
+
+	if result == walk.DlgCmdOK {
+		mw.redrawChart()
+		mw.showResults()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeviceSeries(t *testing.T) {
+	values := map[string][]byte{
+		"/bw/bwCurrentUsed":                []byte("500"),
+		"/bw/devices/tv/01":                []byte("10.5"),
+		"/bw/devices/tv/02":                []byte("4.5"),
+		"/bw/devices/laptop/02":            []byte("20"),
+		"/bw/devices/laptop/32":            []byte("1"),
+		"/bw/devices/phone/02":             []byte("lots"),
+		"/bw/history/2024-02/devices/tv/1": []byte("3"),
+	}
+	series := getDeviceSeries(values, "/bw")
+	expected := map[string]map[string]float64{
+		"tv":     {"01": 10.5, "02": 4.5},
+		"laptop": {"02": 20},
+	}
+	if !reflect.DeepEqual(series, expected) {
+		t.Fatalf("ERROR: Expected: %v got: %v", expected, series)
+	}
+
+	usages := summarizeDevices(series, "02")
+	expUsages := []deviceUsage{{name: "laptop", today: 20, total: 20}, {name: "tv", today: 4.5, total: 15}}
+	if !reflect.DeepEqual(usages, expUsages) {
+		t.Errorf("ERROR: Expected: %v got: %v", expUsages, usages)
+	}
+
+	lines := strings.Split(strings.TrimSpace(formatDeviceTable(usages)), "\r\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[2]), " ") != "tv 4.50 15.00 42.9%" {
+		t.Errorf("ERROR: Expected: tv 4.50 15.00 42.9%% got: %q", lines)
+	}
+}
+
+func TestDeviceStackedBars(t *testing.T) {
+	series := map[string]map[string]float64{
+		"tv":     {"16": 3, "02": 1},
+		"laptop": {"02": 2},
+	}
+	bars := deviceStackedBars(series, 15)
+	if len(bars) != 2 || bars[0].Name != "16" || bars[1].Name != "02" {
+		t.Fatalf("ERROR: Expected: bars for 16 then 02 got: %v", bars)
+	}
+	if len(bars[1].Values) != 2 || bars[1].Values[0].Label != "laptop" || bars[1].Values[1].Value != 1 {
+		t.Errorf("ERROR: Expected: laptop then tv got: %v", bars[1].Values)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/wcharczuk/go-chart"
+)
+
+// What a cap counts, set per plan. bwCurrentUsed is always both directions together, with the
+// upload part of it kept in bwUploadUsed
+const (
+	capCountsBoth     = "both"
+	capCountsDownload = "download"
+)
+
+// Returns how much of the reading counts against the cap
+func countedUsage(total, upload float64, capCounts string) float64 {
+	if capCounts == capCountsDownload {
+		return total - upload
+	}
+
+	return total
+}
+
+// Returns the values of a subkey holding day numbered values, keyed by the two digit day
+func getDaySeries(values map[string][]byte, subkey string) map[string]float64 {
+	series := map[string]float64{}
+	for k, v := range values {
+		if !strings.HasPrefix(k, subkey+"/") || !isValidDayKey(k[len(subkey)+1:]) {
+			continue
+		}
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+			series[k[len(subkey)+1:]] = f
+		}
+	}
+
+	return series
+}
+
+// Turns end of day running totals into what was used each day. The first day recorded in the
+// cycle counts everything up to it
+func dailyFromRunningTotals(totals map[string]float64, startDay int) map[string]float64 {
+	daily := map[string]float64{}
+	previous := 0.0
+	for _, day := range cycleDayOrder(startDay) {
+		strDay := getStrDayOfMonth(day)
+		total, ok := totals[strDay]
+		if !ok {
+			continue
+		}
+		daily[strDay] = total - previous
+		previous = total
+	}
+
+	return daily
+}
+
+// Returns one bar per day stacking download on upload, from the running totals of both
+// directions and of upload alone
+func directionStackedBars(used, upload map[string]float64, startDay int) []chart.StackedBar {
+	dailyUsed := dailyFromRunningTotals(used, startDay)
+	dailyUpload := dailyFromRunningTotals(upload, startDay)
+
+	bars := []chart.StackedBar{}
+	for _, day := range cycleDayOrder(startDay) {
+		strDay := getStrDayOfMonth(day)
+		total, ok := dailyUsed[strDay]
+		if !ok {
+			continue
+		}
+		bar := chart.StackedBar{Name: strDay, Width: 20}
+		if down := total - dailyUpload[strDay]; down > 0 {
+			bar.Values = append(bar.Values, chart.Value{Label: "down", Value: down})
+		}
+		if up := dailyUpload[strDay]; up > 0 {
+			bar.Values = append(bar.Values, chart.Value{Label: "up", Value: up})
+		}
+		if len(bar.Values) > 0 {
+			bars = append(bars, bar)
+		}
+	}
+
+	return bars
+}
+
+// Puts the readings we have into the text boxes, an upload of nothing is left blank
+func (mw *MainWin) showReadings() {
+	mw.bwTextBox.SetText(strconv.FormatFloat(mw.config.bwCurrentUsed, 'f', -1, 64))
+	upload := ""
+	if mw.config.bwUploadUsed != 0 {
+		upload = strconv.FormatFloat(mw.config.bwUploadUsed, 'f', -1, 64)
+	}
+	mw.upTextBox.SetText(upload)
+}
+
+// Returns true if something has been typed in the reading boxes that hasn't been calculated
+func (mw *MainWin) readingsEdited() bool {
+	upload, _ := strconv.ParseFloat(strings.TrimSpace(mw.upTextBox.Text()), 64)
+
+	return mw.bwTextBox.Text() != strconv.FormatFloat(mw.config.bwCurrentUsed, 'f', -1, 64) ||
+		upload != mw.config.bwUploadUsed
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCountedUsage(t *testing.T) {
+	tests := []struct {
+		name      string
+		capCounts string
+		expected  float64
+	}{
+		{"Check both directions count", capCountsBoth, 500},
+		{"Check download only leaves out upload", capCountsDownload, 420},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if counted := countedUsage(500, 80, test.capCounts); counted != test.expected {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, counted)
+			}
+		})
+	}
+}
+
+func TestDailyFromRunningTotals(t *testing.T) {
+	values := map[string][]byte{
+		"/bw/usedByDay/16":   []byte("40"),
+		"/bw/usedByDay/18":   []byte("100"),
+		"/bw/usedByDay/02":   []byte("130"),
+		"/bw/usedByDay/xx":   []byte("5"),
+		"/bw/uploadByDay/02": []byte("12"),
+	}
+	totals := getDaySeries(values, "/bw/usedByDay")
+	expTotals := map[string]float64{"16": 40, "18": 100, "02": 130}
+	if !reflect.DeepEqual(totals, expTotals) {
+		t.Fatalf("ERROR: Expected: %v got: %v", expTotals, totals)
+	}
+
+	daily := dailyFromRunningTotals(totals, 15)
+	expDaily := map[string]float64{"16": 40, "18": 60, "02": 30}
+	if !reflect.DeepEqual(daily, expDaily) {
+		t.Errorf("ERROR: Expected: %v got: %v", expDaily, daily)
+	}
+}
+
+func TestDirectionStackedBars(t *testing.T) {
+	used := map[string]float64{"01": 30, "02": 50}
+	upload := map[string]float64{"01": 5, "02": 5}
+	bars := directionStackedBars(used, upload, 1)
+	if len(bars) != 2 || bars[0].Name != "01" || bars[1].Name != "02" {
+		t.Fatalf("ERROR: Expected: bars for 01 then 02 got: %v", bars)
+	}
+	if len(bars[0].Values) != 2 || bars[0].Values[0].Value != 25 || bars[0].Values[1].Value != 5 {
+		t.Errorf("ERROR: Expected: 25 down and 5 up got: %v", bars[0].Values)
+	}
+	if len(bars[1].Values) != 1 || bars[1].Values[0].Label != "down" || bars[1].Values[0].Value != 20 {
+		t.Errorf("ERROR: Expected: only 20 down got: %v", bars[1].Values)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Once the GB per day remaining drops below a threshold the router is asked to shape traffic
+// down to a set rate, until the next billing cycle starts. The cycle shaping was applied in is
+// kept as enforced (YYYY-MM) under the profile, so it is lifted even if the app was closed
+
+const (
+	enforcerUbus = "ubus"
+	enforcerSSH  = "ssh"
+
+	ubusTimeout       = 10 * time.Second
+	ubusNoSession     = "00000000000000000000000000000000"
+	sshConnectTimeout = 10 // seconds
+	sshTimeout        = 30 * time.Second
+)
+
+// What to do with the router's shaping after a calculation
+const (
+	enforceNothing = iota
+	enforceApply
+	enforceLift
+)
+
+// Applies and lifts bandwidth shaping on a router
+type Enforcer interface {
+	Limit(rateKbit int) error
+	Lift() error
+}
+
+// Router section of config.yml
+type EnforceConfig struct {
+	Router    string  `yaml:"router"`    // ubus or ssh, leave out to never shape
+	Threshold float64 `yaml:"threshold"` // GB per day remaining below which shaping starts
+	RateKbit  int     `yaml:"rateKbit"`  // rate to shape to
+	URL       string  `yaml:"url"`       // ubus: the router's ubus url, like http://192.168.1.1/ubus
+	Username  string  `yaml:"username"`  // ubus: rpcd login
+	Password  string  `yaml:"password"`
+	Section   string  `yaml:"section"` // ubus: sqm section to turn on and off
+	Host      string  `yaml:"host"`    // ssh: user@router
+	KeyFile   string  `yaml:"keyFile"` // ssh: identity file, or the ssh defaults if left out
+	Device    string  `yaml:"device"`  // ssh: interface to shape
+	Profile   string  `yaml:"profile"` // with profiles, the one whose budget the router follows
+}
+
+var validDevice = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Checks the router settings, one error per problem
+func (cfg EnforceConfig) validate() []error {
+	errs := []error{}
+	switch cfg.Router {
+	case "":
+		return errs
+	case enforcerUbus:
+		if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+			errs = append(errs, fmt.Errorf("enforce.url must be an http or https url, got %q", cfg.URL))
+		}
+		if cfg.Section == "" {
+			errs = append(errs, errors.New("enforce.section must name the sqm section to use"))
+		}
+	case enforcerSSH:
+		if cfg.Host == "" {
+			errs = append(errs, errors.New("enforce.host must be set for ssh"))
+		} else if strings.HasPrefix(cfg.Host, "-") {
+			errs = append(errs, fmt.Errorf("enforce.host must be a host, not an ssh option, got %q", cfg.Host))
+		}
+		if !validDevice.MatchString(cfg.Device) {
+			errs = append(errs, fmt.Errorf("enforce.device must be an interface name, got %q", cfg.Device))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("enforce.router must be %s or %s, got %q", enforcerUbus, enforcerSSH, cfg.Router))
+	}
+	if cfg.Threshold <= 0 {
+		errs = append(errs, fmt.Errorf("enforce.threshold must be a positive number of GB per day, got %v", cfg.Threshold))
+	}
+	if cfg.RateKbit <= 0 {
+		errs = append(errs, fmt.Errorf("enforce.rateKbit must be a positive rate, got %d", cfg.RateKbit))
+	}
+
+	return errs
+}
+
+// Returns the enforcer for the configured router, nil if shaping isn't configured
+func newEnforcer(cfg EnforceConfig) Enforcer {
+	switch cfg.Router {
+	case enforcerUbus:
+		return &ubusEnforcer{url: cfg.URL, username: cfg.Username, password: cfg.Password, section: cfg.Section,
+			client: &http.Client{Timeout: ubusTimeout}}
+	case enforcerSSH:
+		return &sshEnforcer{host: cfg.Host, keyFile: cfg.KeyFile, device: cfg.Device, run: runCommandOutput}
+	default:
+		return nil
+	}
+}
+
+// Returns true if the named profile's budget is the one the router follows. There is only the one
+// budget without profiles, otherwise it is the profile set in enforce
+func (cfg Config) enforcesProfile(name string) bool {
+	return len(cfg.Profiles) == 0 || keySlug(name) == keySlug(cfg.Enforce.Profile)
+}
+
+// Works out whether to shape or lift, given the per day remaining and the cycle shaping was
+// applied in (empty if it isn't)
+func enforceDecision(gbPerDayLeft, threshold float64, appliedIn, cycle string) int {
+	switch {
+	case appliedIn != "" && appliedIn != cycle:
+		return enforceLift
+	case appliedIn == "" && gbPerDayLeft < threshold:
+		return enforceApply
+	default:
+		return enforceNothing
+	}
+}
+
+// OpenWrt through rpcd's ubus JSON-RPC, turning an sqm section on at the limited rate and off
+// again to lift it
+type ubusEnforcer struct {
+	url, username, password, section string
+	client                           *http.Client
+}
+
+// Calls a ubus method and returns what it answered with
+func (u *ubusEnforcer) call(session, object, method string, args map[string]interface{}) (map[string]interface{}, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "call",
+		"params":  []interface{}{session, object, method, args},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.client.Post(u.url, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return nil, &storeError{Store: "router", Op: object + " " + method, Key: u.url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, &storeError{Store: "router", Op: object + " " + method, Key: u.url, Err: err}
+	}
+	if reply.Error != nil {
+		return nil, &storeError{Store: "router", Op: object + " " + method, Key: u.url, Err: errors.New(reply.Error.Message)}
+	}
+	var code int
+	if len(reply.Result) == 0 || json.Unmarshal(reply.Result[0], &code) != nil || code != 0 {
+		return nil, &storeError{Store: "router", Op: object + " " + method, Key: u.url, Err: fmt.Errorf("ubus status %d", code)}
+	}
+	values := map[string]interface{}{}
+	if len(reply.Result) > 1 {
+		_ = json.Unmarshal(reply.Result[1], &values)
+	}
+
+	return values, nil
+}
+
+// Logs in, sets the sqm section's values and restarts sqm so they take effect
+func (u *ubusEnforcer) setSQM(values map[string]interface{}) error {
+	login, err := u.call(ubusNoSession, "session", "login", map[string]interface{}{"username": u.username, "password": u.password})
+	if err != nil {
+		return err
+	}
+	session, _ := login["ubus_rpc_session"].(string)
+	if session == "" {
+		return &storeError{Store: "router", Op: "session login", Key: u.url, Err: errors.New("no session returned")}
+	}
+
+	if _, err = u.call(session, "uci", "set", map[string]interface{}{"config": "sqm", "section": u.section, "values": values}); err != nil {
+		return err
+	}
+	if _, err = u.call(session, "uci", "commit", map[string]interface{}{"config": "sqm"}); err != nil {
+		return err
+	}
+	_, err = u.call(session, "rc", "init", map[string]interface{}{"name": "sqm", "action": "restart"})
+
+	return err
+}
+
+func (u *ubusEnforcer) Limit(rateKbit int) error {
+	rate := fmt.Sprint(rateKbit)
+	return u.setSQM(map[string]interface{}{"enabled": "1", "download": rate, "upload": rate})
+}
+
+func (u *ubusEnforcer) Lift() error {
+	return u.setSQM(map[string]interface{}{"enabled": "0"})
+}
+
+// Any router with tc that we can ssh into, using the ssh client on the path
+type sshEnforcer struct {
+	host, keyFile, device string
+	run                   func(name string, args ...string) ([]byte, error)
+}
+
+// Runs a command, killing it if it hasn't finished in time
+func runCommandOutput(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sshTimeout)
+	defer cancel()
+
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// Runs a command on the router
+func (s *sshEnforcer) remote(command string) ([]byte, error) {
+	args := []string{"-o", "BatchMode=yes", "-o", fmt.Sprintf("ConnectTimeout=%d", sshConnectTimeout)}
+	if s.keyFile != "" {
+		args = append(args, "-i", s.keyFile)
+	}
+	args = append(args, "--", s.host, command)
+
+	return s.run("ssh", args...)
+}
+
+func (s *sshEnforcer) Limit(rateKbit int) error {
+	out, err := s.remote(fmt.Sprintf("tc qdisc replace dev %s root tbf rate %dkbit burst 32kbit latency 400ms", s.device, rateKbit))
+	if err != nil {
+		return &storeError{Store: "router", Op: "limit", Key: s.host, Err: fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))}
+	}
+
+	return nil
+}
+
+func (s *sshEnforcer) Lift() error {
+	out, err := s.remote(fmt.Sprintf("tc qdisc del dev %s root", s.device))
+	if err != nil && !strings.Contains(string(out), "No such file or directory") { // nothing to lift
+		return &storeError{Store: "router", Op: "lift", Key: s.host, Err: fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))}
+	}
+
+	return nil
+}
+
+// What the router needs doing after a calculation, with everything it takes copied out of the
+// config so the router can be talked to away from the gui thread
+type enforceStep struct {
+	decision         int
+	enforcer         Enforcer
+	cfg              EnforceConfig
+	profile          string
+	appliedIn, cycle string
+	gbPerDayLeft     float64
+}
+
+// Works out whether the router needs shaping or lifting according to the last calculation
+func (mw *MainWin) enforceStep() (enforceStep, error) {
+	step := enforceStep{decision: enforceNothing, enforcer: newEnforcer(mw.config.Enforce), cfg: mw.config.Enforce,
+		profile: mw.profile, gbPerDayLeft: mw.config.gbPerDayLeft}
+	if step.enforcer == nil || !mw.config.enforcesProfile(mw.profile) { // shaping belongs to another profile
+		return step, nil
+	}
+	var err error
+	if step.appliedIn, err = mw.getStoredValue(regValue14); err != nil {
+		return step, err
+	}
+	step.cycle = cycleStart(time.Now(), mw.config.CycleStartDay).Format("2006-01")
+	step.decision = enforceDecision(step.gbPerDayLeft, step.cfg.Threshold, step.appliedIn, step.cycle)
+
+	return step, nil
+}
+
+// Shapes or lifts on the router, doesn't touch the gui or the store
+func (step enforceStep) run() error {
+	switch step.decision {
+	case enforceApply:
+		return step.enforcer.Limit(step.cfg.RateKbit)
+	case enforceLift:
+		return step.enforcer.Lift()
+	}
+
+	return nil
+}
+
+// Remembers which cycle shaping was applied in once the router has done it
+func (mw *MainWin) recordEnforcement(step enforceStep) error {
+	switch step.decision {
+	case enforceApply:
+		zap.L().Info("shaping router", zap.String("router", step.cfg.Router), zap.Int("rateKbit", step.cfg.RateKbit),
+			zap.Float64("gbPerDayLeft", step.gbPerDayLeft), zap.Float64("threshold", step.cfg.Threshold))
+		return mw.setStoredValue(regValue14, step.cycle)
+	case enforceLift:
+		zap.L().Info("lifted router shaping for the new cycle", zap.String("router", step.cfg.Router), zap.String("appliedIn", step.appliedIn))
+		return mw.setStoredValue(regValue14, "")
+	}
+
+	return nil
+}
+
+// Shapes or lifts on the router according to the last calculation, remembering which cycle
+// shaping was applied in
+func (mw *MainWin) enforceBudget() error {
+	step, err := mw.enforceStep()
+	if err != nil || step.decision == enforceNothing {
+		return err
+	}
+	if err = step.run(); err != nil {
+		return err
+	}
+
+	return mw.recordEnforcement(step)
+}
+
+// Same as enforceBudget, but talks to the router in the background so a router that doesn't
+// answer can't hang the window. Only the result is handled back on the gui thread
+func (mw *MainWin) enforceBudgetInBackground() {
+	step, err := mw.enforceStep()
+	if err != nil {
+		zap.L().Warn("router shaping not updated", zap.Error(err))
+		return
+	}
+	if step.decision == enforceNothing || mw.enforcing {
+		return
+	}
+
+	mw.enforcing = true
+	go func() {
+		err := step.run()
+		mw.Synchronize(func() {
+			mw.enforcing = false
+			if err == nil && step.profile != mw.profile { // switched away, it is picked up again next time
+				return
+			}
+			if err == nil {
+				err = mw.recordEnforcement(step)
+			}
+			if err != nil {
+				zap.L().Warn("router shaping not updated", zap.Error(err))
+			}
+		})
+	}()
+}
+
+// Reads a single value of the profile in use from whichever store is in use
+func (mw *MainWin) getStoredValue(regValue string) (string, error) {
+	if mw.useEtcd {
+		return string(mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue]), nil
+	}
+
+	return mw.GetRegStringValue(mw.regName(regValue))
+}
+
+// Writes a single value of the profile in use to whichever store is in use
+func (mw *MainWin) setStoredValue(regValue, value string) error {
+	if !mw.useEtcd {
+		return mw.setRegValues(auditEnforce, map[string]string{mw.regName(regValue): value})
+	}
+	key := mw.config.Etcd.BaseKeyToWrite + "/" + regValue
+	if err := mw.etcd.commit(mw.withAudit(auditEnforce, mw.config.dbValues, map[string]string{key: value})); err != nil {
+		return &storeError{Store: "etcd", Op: "write", Key: key, Err: err}
+	}
+	if mw.config.dbValues == nil {
+		mw.config.dbValues = map[string][]byte{}
+	}
+	mw.config.dbValues[key] = []byte(value)
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnforceDecision(t *testing.T) {
+	tests := []struct {
+		name       string
+		perDayLeft float64
+		appliedIn  string
+		expected   int
+	}{
+		{"Check under threshold applies", 8, "", enforceApply},
+		{"Check over threshold does nothing", 12, "", enforceNothing},
+		{"Check already applied this cycle does nothing", 2, "2023-02", enforceNothing},
+		{"Check applied last cycle lifts", 40, "2023-01", enforceLift},
+		{"Check applied last cycle lifts even if still under", 2, "2023-01", enforceLift},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if decision := enforceDecision(test.perDayLeft, 10, test.appliedIn, "2023-02"); decision != test.expected {
+				t.Errorf("ERROR: Expected: %d got: %d", test.expected, decision)
+			}
+		})
+	}
+}
+
+func TestEnforceValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      EnforceConfig
+		expected int
+	}{
+		{"Check off", EnforceConfig{}, 0},
+		{"Check ubus", EnforceConfig{Router: "ubus", Threshold: 10, RateKbit: 2000, URL: "http://192.168.1.1/ubus", Section: "eth1"}, 0},
+		{"Check ssh", EnforceConfig{Router: "ssh", Threshold: 10, RateKbit: 2000, Host: "root@router", Device: "eth0.2"}, 0},
+		{"Check unknown router", EnforceConfig{Router: "snmp", Threshold: 10, RateKbit: 2000}, 1},
+		{"Check ubus without url or section", EnforceConfig{Router: "ubus", Threshold: 10, RateKbit: 2000}, 2},
+		{"Check ssh device can't run commands", EnforceConfig{Router: "ssh", Threshold: 10, RateKbit: 2000, Host: "router", Device: "eth0; reboot"}, 1},
+		{"Check ssh host can't be an option", EnforceConfig{Router: "ssh", Threshold: 10, RateKbit: 2000, Host: "-oProxyCommand=calc", Device: "eth0"}, 1},
+		{"Check threshold and rate", EnforceConfig{Router: "ssh", Host: "router", Device: "eth0"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errs := test.cfg.validate(); len(errs) != test.expected {
+				t.Errorf("ERROR: Expected: %d errors got: %v", test.expected, errs)
+			}
+		})
+	}
+}
+
+// Answers ubus calls like rpcd, recording what was called and the sqm values set
+func fakeUbus(t *testing.T, calls *[]string, set *map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Params) != 4 {
+			t.Errorf("ERROR: Bad request: %v", err)
+			return
+		}
+		var session, object, method string
+		_ = json.Unmarshal(request.Params[0], &session)
+		_ = json.Unmarshal(request.Params[1], &object)
+		_ = json.Unmarshal(request.Params[2], &method)
+		args := map[string]interface{}{}
+		_ = json.Unmarshal(request.Params[3], &args)
+		*calls = append(*calls, object+" "+method)
+
+		switch {
+		case object == "session":
+			if args["password"] != "secret" {
+				_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[6]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[0,{"ubus_rpc_session":"abc"}]}`))
+			return
+		case session != "abc":
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"Access denied"}}`))
+			return
+		case object == "uci" && method == "set":
+			*set, _ = args["values"].(map[string]interface{})
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[0]}`))
+	}))
+}
+
+func TestUbusEnforcer(t *testing.T) {
+	var calls []string
+	var set map[string]interface{}
+	server := fakeUbus(t, &calls, &set)
+	defer server.Close()
+
+	enforcer := newEnforcer(EnforceConfig{Router: "ubus", URL: server.URL, Username: "root", Password: "secret", Section: "eth1"})
+	if err := enforcer.Limit(2000); err != nil {
+		t.Fatalf("ERROR: Limit failed: %v", err)
+	}
+	expectedCalls := []string{"session login", "uci set", "uci commit", "rc init"}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Errorf("ERROR: Expected: %v got: %v", expectedCalls, calls)
+	}
+	expectedSet := map[string]interface{}{"enabled": "1", "download": "2000", "upload": "2000"}
+	if !reflect.DeepEqual(set, expectedSet) {
+		t.Errorf("ERROR: Expected: %v got: %v", expectedSet, set)
+	}
+
+	if err := enforcer.Lift(); err != nil {
+		t.Fatalf("ERROR: Lift failed: %v", err)
+	}
+	if expectedSet = map[string]interface{}{"enabled": "0"}; !reflect.DeepEqual(set, expectedSet) {
+		t.Errorf("ERROR: Expected: %v got: %v", expectedSet, set)
+	}
+
+	wrong := newEnforcer(EnforceConfig{Router: "ubus", URL: server.URL, Username: "root", Password: "wrong", Section: "eth1"})
+	if err := wrong.Limit(2000); err == nil || !strings.Contains(err.Error(), "ubus status 6") {
+		t.Errorf("ERROR: Expected: ubus status 6 got: %v", err)
+	}
+}
+
+func TestSSHEnforcer(t *testing.T) {
+	var commands []string
+	output, failure := "", error(nil)
+	enforcer := &sshEnforcer{host: "root@router", keyFile: "router.key", device: "eth0", run: func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return []byte(output), failure
+	}}
+
+	if err := enforcer.Limit(2000); err != nil {
+		t.Fatalf("ERROR: Limit failed: %v", err)
+	}
+	if err := enforcer.Lift(); err != nil {
+		t.Fatalf("ERROR: Lift failed: %v", err)
+	}
+	expected := []string{
+		"ssh -o BatchMode=yes -o ConnectTimeout=10 -i router.key -- root@router tc qdisc replace dev eth0 root tbf rate 2000kbit burst 32kbit latency 400ms",
+		"ssh -o BatchMode=yes -o ConnectTimeout=10 -i router.key -- root@router tc qdisc del dev eth0 root",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("ERROR: Expected: %q got: %q", expected, commands)
+	}
+
+	output, failure = "RTNETLINK answers: No such file or directory", errors.New("exit status 2")
+	if err := enforcer.Lift(); err != nil {
+		t.Errorf("ERROR: Expected: lifting when not shaped to succeed got: %v", err)
+	}
+	output = "ssh: connect to host router port 22: Connection refused"
+	if err := enforcer.Limit(2000); err == nil || !strings.Contains(err.Error(), "Connection refused") {
+		t.Errorf("ERROR: Expected: the ssh output in the error got: %v", err)
+	}
+}
+
+func TestEnforcesProfile(t *testing.T) {
+	cfg := Config{Cap: 1229}
+	cfg.Etcd.BaseKeyToWrite = "/nate/CalcBandwidth"
+	cfg.Enforce = EnforceConfig{Router: "ssh", Threshold: 10, RateKbit: 2000, Host: "root@router", Device: "eth0"}
+	cfg.Profiles = []Profile{{Name: "Home cable"}, {Name: "Cabin LTE", Cap: 50, CycleStartDay: 15}}
+	cfg.applyDefaults()
+	if cfg.Enforce.Profile != "Home cable" {
+		t.Errorf("ERROR: Expected: the router to follow the default profile got: %q", cfg.Enforce.Profile)
+	}
+
+	// switching to the other profile must not shape or lift on its budget
+	for _, test := range []struct {
+		profile  string
+		expected bool
+	}{{"Cabin LTE", false}, {"Home cable", true}} {
+		name, _ := cfg.useProfile(test.profile)
+		if enforced := cfg.enforcesProfile(name); enforced != test.expected {
+			t.Errorf("ERROR: Expected: %v for %s got: %v", test.expected, name, enforced)
+		}
+	}
+
+	cfg.Enforce.Profile = "Mobile hotspot"
+	if errs := cfg.validate(); len(errs) != 1 {
+		t.Errorf("ERROR: Expected: 1 error for an unknown profile got: %v", errs)
+	}
+	if !(Config{}).enforcesProfile("") {
+		t.Errorf("ERROR: Expected: the only budget to be enforced without profiles")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+)
+
+// Returned (wrapped) when etcd doesn't answer within the configured timeout, this is
+// recoverable so callers should queue what they were doing or fall back rather than exit
+var errEtcdTimeout = errors.New("etcd did not respond in time")
+
+// Returned by the calculation when the upload typed in is more than the total used
+var errUploadOverUsed = errors.New("upload is more than the bandwidth used")
+
+// Error from a storage or chart operation, saying which part failed so it can be shown
+// to the user without exiting and losing what they typed
+type storeError struct {
+	Store string // etcd, registry, config, journal or chart
+	Op    string
+	Key   string
+	Err   error
+}
+
+func (e *storeError) Error() string {
+	msg := e.Store + " " + e.Op
+	if e.Key != "" {
+		msg += " " + e.Key
+	}
+
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *storeError) Unwrap() error {
+	return e.Err
+}
+
+// Returns true if etcd is only unreachable for now, so the operation can be queued and retried
+func isUnreachable(err error) bool {
+	return errors.Is(err, errEtcdTimeout)
+}
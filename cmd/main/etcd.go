@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultEtcdTimeout = 5 // seconds, used when config.yml doesn't set one
+	etcdRetries        = 3
+	etcdRetryBackoff   = 250 * time.Millisecond
+	etcdMaxTxnOps      = 128 // etcd's default --max-txn-ops
+)
+
+// Wraps the etcd client so every operation runs with the configured timeout and retries
+type etcdStore struct {
+	client   *clientv3.Client
+	timeout  time.Duration
+	servedBy uint64 // member id that answered the last read
+}
+
+// Returns the configured etcd timeout, or the default if none was set
+func (mw *MainWin) etcdTimeout() time.Duration {
+	if mw.config.Etcd.Timeout <= 0 {
+		return defaultEtcdTimeout * time.Second
+	}
+
+	return time.Duration(mw.config.Etcd.Timeout) * time.Second
+}
+
+// Builds the client config (TLS, auth and timeout) for the configured endpoints
+func etcdClientConfig(cfg EtcdConfig, timeout time.Duration) (clientv3.Config, error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return clientv3.Config{}, err
+	}
+
+	return clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		DialTimeout: timeout,
+		TLS:         tlsConfig,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+	}, nil
+}
+
+// Connects using the client config, trying its endpoints in order of preference
+func newEtcdStore(clientConfig clientv3.Config) (*etcdStore, error) {
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errEtcdTimeout, err)
+	}
+
+	return &etcdStore{client: client, timeout: clientConfig.DialTimeout}, nil
+}
+
+// Connects to the given endpoints with the configured TLS and auth settings
+func (mw *MainWin) connectEtcd(endpoints []string) (*etcdStore, error) {
+	clientConfig, err := etcdClientConfig(mw.config.Etcd, mw.etcdTimeout())
+	if err != nil {
+		return nil, err
+	}
+	clientConfig.Endpoints = endpoints
+
+	return newEtcdStore(clientConfig)
+}
+
+func (s *etcdStore) close() {
+	if s != nil && s.client != nil {
+		s.client.Close()
+	}
+}
+
+// Returns true if the error is worth retrying (timeouts and unreachable members)
+func isRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Unavailable:
+		return true
+	}
+
+	return false
+}
+
+// Runs op, retrying with a back off while there is time left. All the attempts share the one
+// timeout, so a cluster that doesn't answer holds things up no longer than that
+func (s *etcdStore) withRetry(op func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var err error
+	backoff := etcdRetryBackoff
+	attempt := 1
+	for ; attempt <= etcdRetries; attempt++ {
+		err = op(ctx)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt == etcdRetries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %d attempts: %v", errEtcdTimeout, attempt, err)
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+
+	return fmt.Errorf("%w after %d attempts: %v", errEtcdTimeout, attempt, err)
+}
+
+// Reads every key under the prefix, along with the revision each key was last modified at
+func (s *etcdStore) read(prefix string) (map[string][]byte, map[string]int64, error) {
+	started := time.Now()
+	values := map[string][]byte{}
+	revisions := map[string]int64{}
+	err := s.withRetry(func(ctx context.Context) error {
+		resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+		if err != nil {
+			return err
+		}
+		for _, kv := range resp.Kvs {
+			values[string(kv.Key)] = kv.Value
+			revisions[string(kv.Key)] = kv.ModRevision
+		}
+		s.servedBy = resp.Header.MemberId
+		return nil
+	})
+	logStoreOp("etcd", "read", prefix, started, err, zap.Int("keys", len(values)), zap.Uint64("member", s.servedBy))
+
+	return values, revisions, err
+}
+
+func (s *etcdStore) write(key, value string) error {
+	started := time.Now()
+	err := s.withRetry(func(ctx context.Context) error {
+		_, err := s.client.Put(ctx, key, value)
+		return err
+	})
+	logStoreOp("etcd", "write", key, started, err, zap.String("value", value))
+
+	return err
+}
+
+func (s *etcdStore) delete(key string) error {
+	started := time.Now()
+	err := s.withRetry(func(ctx context.Context) error {
+		_, err := s.client.Delete(ctx, key)
+		return err
+	})
+	logStoreOp("etcd", "delete", key, started, err)
+
+	return err
+}
+
+// Deletes every key from start up to (not including) end
+func (s *etcdStore) deleteRange(start, end string) error {
+	started := time.Now()
+	err := s.withRetry(func(ctx context.Context) error {
+		_, err := s.client.Delete(ctx, start, clientv3.WithRange(end))
+		return err
+	})
+	logStoreOp("etcd", "delete range", start, started, err, zap.String("end", end))
+
+	return err
+}
+
+// Applies all the puts and prefix deletes in a single transaction so they either all land or none do
+func (s *etcdStore) commit(puts map[string]string, deletePrefixes ...string) error {
+	_, err := s.commitIf(puts, nil, nil, deletePrefixes...)
+
+	return err
+}
+
+// Writes the puts in as few transactions as etcd allows, for bulk writes where all or
+// nothing isn't needed. Returns how many were written before any error
+func (s *etcdStore) commitBatches(puts map[string]string) (int, error) {
+	written := 0
+	batch := map[string]string{}
+	for _, k := range sortedStringKeys(puts) {
+		batch[k] = puts[k]
+		if len(batch) == etcdMaxTxnOps || len(batch)+written == len(puts) {
+			if err := s.commit(batch); err != nil {
+				return written, err
+			}
+			written += len(batch)
+			batch = map[string]string{}
+		}
+	}
+
+	return written, nil
+}
+
+// Same as commit, but only applies if none of the expected keys have been modified since the
+// given revision (0 meaning the key must not exist). Keys in deletes are deleted on their own,
+// unlike the prefixes. Returns false if another client changed one of them first, in which case
+// nothing was written
+func (s *etcdStore) commitIf(puts map[string]string, expected map[string]int64, deletes []string, deletePrefixes ...string) (bool, error) {
+	if ops := len(puts) + len(deletes) + len(deletePrefixes); ops > etcdMaxTxnOps || len(expected) > etcdMaxTxnOps {
+		return false, fmt.Errorf("%d operations is more than etcd allows in one transaction", ops)
+	}
+	cmps := []clientv3.Cmp{}
+	for k, rev := range expected {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(k), "=", rev))
+	}
+	ops := []clientv3.Op{}
+	for k, v := range puts {
+		ops = append(ops, clientv3.OpPut(k, v))
+	}
+	for _, k := range deletes {
+		ops = append(ops, clientv3.OpDelete(k))
+	}
+	for _, prefix := range deletePrefixes {
+		ops = append(ops, clientv3.OpDelete(prefix, clientv3.WithPrefix()))
+	}
+
+	started := time.Now()
+	succeeded := false
+	var err error
+	if len(cmps) == 0 { // applying it twice gives the same result, so it is safe to retry
+		err = s.withRetry(func(ctx context.Context) error {
+			resp, err := s.client.Txn(ctx).Then(ops...).Commit()
+			if err == nil {
+				succeeded = resp.Succeeded
+			}
+			return err
+		})
+	} else {
+		// a retry after a timeout could find our own write already there and report a
+		// conflict, so instead look at whether the first attempt landed
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		resp, txnErr := s.client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		cancel()
+		switch {
+		case txnErr == nil:
+			succeeded = resp.Succeeded
+		case isRetryable(txnErr):
+			var landed bool
+			if landed, err = s.committed(puts, deletes, deletePrefixes); err == nil && !landed {
+				err = fmt.Errorf("%w: %v", errEtcdTimeout, txnErr)
+			}
+			succeeded = landed
+		default:
+			err = txnErr
+		}
+	}
+	logStoreOp("etcd", "commit", "", started, err, zap.Strings("puts", sortedStringKeys(puts)),
+		zap.Strings("deletes", deletes), zap.Strings("deletePrefixes", deletePrefixes), zap.Int("expected", len(expected)), zap.Bool("succeeded", succeeded))
+
+	return succeeded, err
+}
+
+// Returns true if every put holds the value it was given and nothing is left under the deletes,
+// meaning a transaction whose outcome wasn't known did land
+func (s *etcdStore) committed(puts map[string]string, deletes, deletePrefixes []string) (bool, error) {
+	ops := []clientv3.Op{}
+	for _, k := range sortedStringKeys(puts) {
+		ops = append(ops, clientv3.OpGet(k))
+	}
+	for _, k := range deletes {
+		ops = append(ops, clientv3.OpGet(k, clientv3.WithCountOnly()))
+	}
+	for _, prefix := range deletePrefixes {
+		ops = append(ops, clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithCountOnly()))
+	}
+
+	landed := true
+	err := s.withRetry(func(ctx context.Context) error {
+		resp, err := s.client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return err
+		}
+		landed = true
+		for i, k := range sortedStringKeys(puts) {
+			kvs := resp.Responses[i].GetResponseRange().Kvs
+			landed = landed && len(kvs) == 1 && string(kvs[0].Value) == puts[k]
+		}
+		for _, op := range resp.Responses[len(puts):] {
+			landed = landed && op.GetResponseRange().Count == 0
+		}
+		return nil
+	})
+
+	return landed, err
+}
+
+// Calls onChange every time something under the prefix is modified, until stop is called.
+// A transaction touching several keys only results in a single call
+func (s *etcdStore) watch(prefix string, onChange func()) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	watchChan := s.client.Watch(clientv3.WithRequireLeader(ctx), prefix, clientv3.WithPrefix())
+
+	go func() {
+		for resp := range watchChan {
+			if resp.Err() == nil && len(resp.Events) > 0 {
+				onChange()
+			}
+		}
+	}()
+
+	return cancel
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+const (
+	exportSectionSummary = "summary"
+	exportSectionDay     = "day"
+	exportSectionSeries  = "series"
+	exportSectionHistory = "history"
+)
+
+// Summary values that can be exported and imported, and how each one is written to the db
+var exportSummaryFormats = map[string]string{
+	regValue1: "%.0f",
+	regValue2: "%.3f",
+	regValue4: "%.0f",
+	regValue5: "%.3f",
+	regValue6: "%.3f",
+	regValue9: "%.0f",
+}
+
+// Everything we export or import, independent of which store it came from. Series holds the
+// cycle's other daily values and the banked lots by their key under the profile, such as
+// usedByDay/05 or devices/pc/05, and each history month is keyed the same way under the month
+type exportData struct {
+	Summary map[string]float64            `json:"summary"`
+	Days    map[string]float64            `json:"dayOfMonth"`
+	Series  map[string]float64            `json:"series,omitempty"`
+	History map[string]map[string]float64 `json:"history,omitempty"`
+}
+
+func newExportData() exportData {
+	return exportData{
+		Summary: map[string]float64{},
+		Days:    map[string]float64{},
+		Series:  map[string]float64{},
+		History: map[string]map[string]float64{},
+	}
+}
+
+// Gathers the summary values, daily series and archived months from whichever store is in use
+func (mw *MainWin) collectExportData() exportData {
+	data := newExportData()
+
+	if !mw.useEtcd { // the registry only holds the summary values
+		for _, regValue := range []string{regValue1, regValue2, regValue9} {
+			value, _ := mw.GetRegStringValue(mw.regName(regValue))
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				data.Summary[regValue] = f
+			}
+		}
+		return data
+	}
+
+	return exportDataFrom(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite)
+}
+
+// Sorts every number stored under the base key into what is exported. Only the cycle the router
+// was shaped in is left out, as it isn't a number
+func exportDataFrom(values map[string][]byte, baseKey string) exportData {
+	data := newExportData()
+	for k, v := range values {
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil || !strings.HasPrefix(k, baseKey+"/") {
+			continue
+		}
+		key := k[len(baseKey)+1:]
+		parts := strings.SplitN(key, "/", 3)
+		switch {
+		case len(parts) == 1:
+			if _, ok := exportSummaryFormats[key]; ok {
+				data.Summary[key] = f
+			}
+		case len(parts) == 2 && parts[0] == regValue3:
+			data.Days[parts[1]] = f
+		case len(parts) == 3 && parts[0] == regValue7:
+			if isValidHistoryKey(parts[2]) {
+				if data.History[parts[1]] == nil {
+					data.History[parts[1]] = map[string]float64{}
+				}
+				data.History[parts[1]][parts[2]] = f
+			}
+		case isValidSeriesKey(key):
+			data.Series[key] = f
+		}
+	}
+
+	return data
+}
+
+// Returns true if the string is a two digit day of month from 01 to 31
+func isValidDayKey(day string) bool {
+	if len(day) != 2 {
+		return false
+	}
+	intDay, err := strconv.Atoi(day)
+
+	return err == nil && intDay >= 1 && intDay <= 31
+}
+
+// Returns true if the key is a day of the cycle's running totals, unmetered usage or a device,
+// or a banked lot
+func isValidSeriesKey(key string) bool {
+	parts := strings.Split(key, "/")
+	switch {
+	case len(parts) == 2 && (parts[0] == regValue10 || parts[0] == regValue11 || parts[0] == regValue12):
+		return isValidDayKey(parts[1])
+	case len(parts) == 2 && parts[0] == regValue13:
+		_, err := time.Parse("2006-01", parts[1])
+		return err == nil
+	case len(parts) == 3 && parts[0] == regValue8:
+		return parts[1] != "" && isValidDayKey(parts[2])
+	}
+
+	return false
+}
+
+// Returns true if the key is one archiveMonth writes under a month
+func isValidHistoryKey(key string) bool {
+	parts := strings.Split(key, "/")
+	switch {
+	case len(parts) == 1:
+		return key == regValue1 || key == regValue9 || key == regValue12 || key == regValue13 || isValidDayKey(key)
+	case len(parts) == 2 && parts[0] == regValue10:
+		return isValidDayKey(parts[1])
+	case len(parts) == 3 && parts[0] == regValue8:
+		return parts[1] != "" && isValidDayKey(parts[2])
+	}
+
+	return false
+}
+
+// Returns how a value is written to the db, by its key under the profile or history month
+func importFormat(key string) string {
+	if format, ok := exportSummaryFormats[key]; ok {
+		return format
+	}
+	if strings.HasPrefix(key, regValue10+"/") || strings.HasPrefix(key, regValue11+"/") {
+		return "%.0f"
+	}
+
+	return "%.3f"
+}
+
+// Checks every imported value before anything is written to the store. The month has to be the
+// one the current cycle started in, any other would have the next redraw archive and delete the
+// cycle's days as if it had just rolled over
+func (data exportData) validate(cycleMonth time.Month) error {
+	errs := []string{}
+
+	for k, v := range data.Summary {
+		if _, ok := exportSummaryFormats[k]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown summary value %q", k))
+		} else if math.IsNaN(v) || math.IsInf(v, 0) {
+			errs = append(errs, fmt.Sprintf("summary value %q is not a number", k))
+		} else if k == regValue4 && (v < 1 || v > 12 || v != math.Trunc(v)) {
+			errs = append(errs, fmt.Sprintf("%s must be a whole number from 1 to 12, got %v", k, v))
+		} else if k == regValue4 && time.Month(v) != cycleMonth {
+			errs = append(errs, fmt.Sprintf("%s is %v but the current cycle started in month %d", k, v, cycleMonth))
+		}
+	}
+	for day, v := range data.Days {
+		if !isValidDayKey(day) {
+			errs = append(errs, fmt.Sprintf("invalid day %q, expected 01 to 31", day))
+		} else if math.IsNaN(v) || math.IsInf(v, 0) {
+			errs = append(errs, fmt.Sprintf("day %s is not a number", day))
+		}
+	}
+	for k, v := range data.Series {
+		if !isValidSeriesKey(k) {
+			errs = append(errs, fmt.Sprintf("unknown series value %q", k))
+		} else if math.IsNaN(v) || math.IsInf(v, 0) {
+			errs = append(errs, fmt.Sprintf("series value %q is not a number", k))
+		}
+	}
+	for month, values := range data.History {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid history month %q, expected YYYY-MM", month))
+		}
+		for k, v := range values {
+			if !isValidHistoryKey(k) {
+				errs = append(errs, fmt.Sprintf("invalid history key %q in %s", k, month))
+			} else if math.IsNaN(v) || math.IsInf(v, 0) {
+				errs = append(errs, fmt.Sprintf("history %s/%s is not a number", month, k))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	return nil
+}
+
+// Writes the data as section,month,key,value rows
+func writeExportCSV(w io.Writer, data exportData) error {
+	rows := [][]string{{"section", "month", "key", "value"}}
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+	for _, k := range sortedKeys(data.Summary) {
+		rows = append(rows, []string{exportSectionSummary, "", k, formatFloat(data.Summary[k])})
+	}
+	for _, day := range sortedKeys(data.Days) {
+		rows = append(rows, []string{exportSectionDay, "", day, formatFloat(data.Days[day])})
+	}
+	for _, k := range sortedKeys(data.Series) {
+		rows = append(rows, []string{exportSectionSeries, "", k, formatFloat(data.Series[k])})
+	}
+	months := []string{}
+	for month := range data.History {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	for _, month := range months {
+		for _, k := range sortedKeys(data.History[month]) {
+			rows = append(rows, []string{exportSectionHistory, month, k, formatFloat(data.History[month][k])})
+		}
+	}
+
+	return csv.NewWriter(w).WriteAll(rows)
+}
+
+// Reads rows written by writeExportCSV back in, the header row is optional
+func readImportCSV(r io.Reader) (exportData, error) {
+	data := newExportData()
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return data, err
+	}
+	for i, row := range rows {
+		if i == 0 && row[0] == "section" {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(row[3]), 64)
+		if err != nil {
+			return data, fmt.Errorf("line %d: value %q is not a number", i+1, row[3])
+		}
+		switch row[0] {
+		case exportSectionSummary:
+			data.Summary[row[2]] = value
+		case exportSectionDay:
+			data.Days[row[2]] = value
+		case exportSectionSeries:
+			data.Series[row[2]] = value
+		case exportSectionHistory:
+			if data.History[row[1]] == nil {
+				data.History[row[1]] = map[string]float64{}
+			}
+			data.History[row[1]][row[2]] = value
+		default:
+			return data, fmt.Errorf("line %d: unknown section %q", i+1, row[0])
+		}
+	}
+
+	return data, nil
+}
+
+func writeExportJSON(w io.Writer, data exportData) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(data)
+}
+
+func readImportJSON(r io.Reader) (exportData, error) {
+	data := newExportData()
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+// Returns the keys of the map in sorted order so exports are stable
+func sortedKeys(m map[string]float64) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Writes the current data to a csv or json file depending on the file extension
+func (mw *MainWin) exportToFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return writeExportJSON(file, mw.collectExportData())
+	}
+
+	return writeExportCSV(file, mw.collectExportData())
+}
+
+// Reads a csv or json file, validates it and merges it into the store in use. Keys in
+// the file overwrite what is stored, anything not in the file is left alone
+func (mw *MainWin) importFromFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("nothing was written, %w", err)
+	}
+	defer file.Close()
+
+	var data exportData
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err = readImportJSON(file)
+	} else {
+		data, err = readImportCSV(file)
+	}
+	if err != nil {
+		return "", fmt.Errorf("nothing was written, %w", err)
+	}
+	if err = data.validate(cycleStart(time.Now(), mw.config.CycleStartDay).Month()); err != nil {
+		return "", fmt.Errorf("nothing was written, the file has:\n%w", err)
+	}
+
+	written, skipped := 0, 0
+	if !mw.useEtcd {
+		values := map[string]string{}
+		for k, v := range data.Summary {
+			if k == regValue1 || k == regValue2 || k == regValue9 {
+				values[mw.regName(k)] = fmt.Sprintf(exportSummaryFormats[k], v)
+			} else {
+				skipped++
+			}
+		}
+		if err = mw.setRegValues(auditImport, values); err != nil {
+			return "", fmt.Errorf("some of the %d values may have been written, the audit trail lists them: %w", len(values), err)
+		}
+		written = len(values)
+		skipped += len(data.Days) + len(data.Series)
+		for _, values := range data.History {
+			skipped += len(values)
+		}
+	} else {
+		toWrite := map[string]string{}
+		for k, v := range data.Summary {
+			toWrite[k] = fmt.Sprintf(importFormat(k), v)
+		}
+		for day, v := range data.Days {
+			toWrite[regValue3+"/"+day] = fmt.Sprintf("%.3f", v)
+		}
+		for k, v := range data.Series {
+			toWrite[k] = fmt.Sprintf(importFormat(k), v)
+		}
+		for month, values := range data.History {
+			for k, v := range values {
+				toWrite[regValue7+"/"+month+"/"+k] = fmt.Sprintf(importFormat(k), v)
+			}
+		}
+		puts := map[string]string{}
+		for k, v := range toWrite {
+			puts[mw.config.Etcd.BaseKeyToWrite+"/"+k] = v
+		}
+		if written, err = mw.commitAuditedBatches(auditImport, mw.config.dbValues, puts); err != nil {
+			return "", fmt.Errorf("stopped after writing %d of %d values, those can be undone from the audit trail: %w", written, len(puts), err)
+		}
+	}
+
+	if used, ok := data.Summary[regValue1]; ok {
+		mw.config.bwCurrentUsed = used
+	}
+	if upload, ok := data.Summary[regValue9]; ok {
+		mw.config.bwUploadUsed = upload
+	}
+	summary := fmt.Sprintf("Imported %d values", written)
+	if skipped > 0 {
+		summary += fmt.Sprintf(", skipped %d the registry can't hold", skipped)
+	}
+
+	return summary, nil
+}
+
+// Asks for a file name and exports to it
+func (mw *MainWin) showExportDialog() {
+	dlg := &walk.FileDialog{
+		Title:    "Export bandwidth data",
+		Filter:   "CSV files (*.csv)|*.csv|JSON files (*.json)|*.json",
+		FilePath: "CalcBandwidth.csv",
+	}
+	if ok, err := dlg.ShowSave(mw); err != nil || !ok {
+		return
+	}
+	if dlg.FilterIndex == 2 && filepath.Ext(dlg.FilePath) == "" {
+		dlg.FilePath += ".json"
+	}
+
+	if err := mw.exportToFile(dlg.FilePath); err != nil {
+		walk.MsgBox(mw, "Error", "Export failed: "+err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	walk.MsgBox(mw, "Info", "Exported to "+dlg.FilePath, walk.MsgBoxIconInformation)
+}
+
+// Asks for a file, imports it and refreshes everything shown
+func (mw *MainWin) showImportDialog() {
+	dlg := &walk.FileDialog{
+		Title:  "Import bandwidth data",
+		Filter: "CSV files (*.csv)|*.csv|JSON files (*.json)|*.json",
+	}
+	if ok, err := dlg.ShowOpen(mw); err != nil || !ok {
+		return
+	}
+
+	if summary, err := mw.importFromFile(dlg.FilePath); err != nil {
+		walk.MsgBox(mw, "Error", "Import failed, "+err.Error(), walk.MsgBoxIconError)
+	} else {
+		walk.MsgBox(mw, "Info", summary, walk.MsgBoxIconInformation)
+	}
+	// refreshed either way, a failed import may have written part of the file
+
+	mw.showReadings()
+	mw.redrawChart()
+	mw.showResults()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	data := newExportData()
+	data.Summary[regValue1] = 612
+	data.Summary[regValue4] = 3
+	data.Days["01"] = 39.645
+	data.Days["02"] = 40.1
+	data.Series["usedByDay/01"] = 40
+	data.Series["devices/pc/02"] = 3.5
+	data.History["2024-02"] = map[string]float64{"01": 42.379, regValue1: 1190, "usedByDay/01": 38, "devices/pc/01": 2.25}
+
+	t.Run("Check csv round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeExportCSV(&buf, data); err != nil {
+			t.Fatalf("ERROR: Expected: no error got: %v", err)
+		}
+		results, err := readImportCSV(&buf)
+		if err != nil {
+			t.Fatalf("ERROR: Expected: no error got: %v", err)
+		}
+		if !reflect.DeepEqual(results, data) {
+			t.Errorf("ERROR: Expected: %v got: %v", data, results)
+		}
+	})
+
+	t.Run("Check json round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeExportJSON(&buf, data); err != nil {
+			t.Fatalf("ERROR: Expected: no error got: %v", err)
+		}
+		results, err := readImportJSON(&buf)
+		if err != nil {
+			t.Fatalf("ERROR: Expected: no error got: %v", err)
+		}
+		if !reflect.DeepEqual(results, data) {
+			t.Errorf("ERROR: Expected: %v got: %v", data, results)
+		}
+	})
+}
+
+func TestImportValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		wantErr bool
+	}{
+		{"Check valid rows", "summary,,bwCurrentUsed,100\nday,,05,40.2\n", false},
+		{"Check day out of range", "day,,32,40.2\n", true},
+		{"Check single digit day", "day,,5,40.2\n", true},
+		{"Check unknown summary value", "summary,,bwSomething,1\n", true},
+		{"Check month out of range", "summary,,monthOfYear,13\n", true},
+		{"Check month of the current cycle", "summary,,monthOfYear,3\n", false},
+		{"Check month of another cycle", "summary,,monthOfYear,2\n", true},
+		{"Check bad history month", "history,2024-13,01,40\n", true},
+		{"Check history series", "history,2024-02,usedByDay/01,40\nhistory,2024-02,devices/pc/01,2\n", false},
+		{"Check unknown history series", "history,2024-02,uploadByDay/01,40\n", true},
+		{"Check series", "series,,uploadByDay/05,12\nseries,,devices/pc/05,1.5\nseries,,banked/2024-02,30\n", false},
+		{"Check series day out of range", "series,,usedByDay/32,40\n", true},
+		{"Check unknown series", "series,,enforced,1\n", true},
+		{"Check non numeric value", "day,,05,abc\n", true},
+		{"Check unknown section", "week,,05,40\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := readImportCSV(strings.NewReader(test.csv))
+			if err == nil {
+				err = data.validate(time.March)
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("ERROR: Expected error: %v got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestExportDataFrom(t *testing.T) {
+	values := map[string][]byte{
+		"bw/" + regValue1:                            []byte("612"),
+		"bw/" + regValue14:                           []byte("2024-03"),
+		"bw/" + regValue3 + "/05":                    []byte("39.645"),
+		"bw/" + regValue10 + "/05":                   []byte("600"),
+		"bw/" + regValue8 + "/pc/05":                 []byte("3.500"),
+		"bw/" + regValue13 + "/2024-02":              []byte("30.000"),
+		"bw/" + regValue7 + "/2024-02/01":            []byte("42.379"),
+		"bw/" + regValue7 + "/2024-02/usedByDay/01":  []byte("38"),
+		"bw/" + regValue7 + "/2024-02/devices/pc/01": []byte("2.250"),
+		"other/" + regValue1:                         []byte("100"),
+	}
+	expected := newExportData()
+	expected.Summary[regValue1] = 612
+	expected.Days["05"] = 39.645
+	expected.Series[regValue10+"/05"] = 600
+	expected.Series[regValue8+"/pc/05"] = 3.5
+	expected.Series[regValue13+"/2024-02"] = 30
+	expected.History["2024-02"] = map[string]float64{"01": 42.379, regValue10 + "/01": 38, regValue8 + "/pc/01": 2.25}
+
+	if results := exportDataFrom(values, "bw"); !reflect.DeepEqual(results, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, results)
+	}
+}
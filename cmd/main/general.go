@@ -3,34 +3,16 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
 	"math"
-	"net"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	"MyLibs/myetcd"
-	"MyLibs/mysupport"
-
 	"github.com/lxn/walk"
+	"go.uber.org/zap"
 	"golang.org/x/sys/windows/registry"
 )
 
-// Config struct
-type Config struct {
-	Etcd struct {
-		// var name has to be uppercase here or it won't work
-		Endpoints      []string `yaml:"endpoints"`
-		BaseKeyToWrite string   `yaml:"baseKeyToWrite"`
-		Timeout        int      `yaml:"timeout"`
-		CertPath       string   `yaml:"certpath"`
-	}
-	dbValues                                  map[string][]byte
-	bwCurrentUsed, gbPerDayLeft, bwMin, bwMax float64
-}
-
 // Returns the number of days in the month
 func (mw *MainWin) calcMonthDays(month time.Month, year int) float64 {
 	var days float64
@@ -61,182 +43,368 @@ func lower(x, y float64) float64 {
 	}
 }
 
-// This does all the calculations that are shown on the screen and returns the string to be printed
-func (mw *MainWin) calculateBandwidth() string {
-	const bwLimitGBs float64 = 1229
+// This does all the calculations that are shown on the screen and returns the string to be printed.
+// An error means the readings were rejected, and nothing worked out from them should be saved
+func (mw *MainWin) calculateBandwidth() (string, error) {
+	start := cycleStart(time.Now(), mw.config.CycleStartDay)
+	banked := mw.bankedForCycle(start)
+	bwLimitGBs := mw.config.Cap + banked // banked data from earlier cycles adds to the cap
 	var err error
 
-	currentYear := time.Now().Year()
-	currentMonth := time.Now().Month()
 	if mw.bwTextBox != nil { // will be nil on initial run of func at opening of program
 		mw.config.bwCurrentUsed, err = strconv.ParseFloat(strings.TrimSpace(mw.bwTextBox.Text()), 64)
 	}
+	if mw.upTextBox != nil && err == nil {
+		mw.config.bwUploadUsed = 0
+		if upload := strings.TrimSpace(mw.upTextBox.Text()); upload != "" { // upload is optional
+			mw.config.bwUploadUsed, err = strconv.ParseFloat(upload, 64)
+		}
+	}
 	if err != nil {
-		log.Println("Invalid characters detected, please use integers only")
-		return ""
+		zap.L().Debug("invalid characters detected, please use integers only", zap.Error(err))
+		return "", err
+	} else if mw.config.bwUploadUsed > mw.config.bwCurrentUsed {
+		return "Upload can't be more than the bandwidth used, which counts both directions\r\n", errUploadOverUsed
 	} else {
-		// find the number of days since the first of the month (excluding today)
-		hoursSinceMonthStart := time.Since(time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, time.Local)).Hours()
-		totalDaysInMonth := mw.calcMonthDays(currentMonth, currentYear)
+		unmetered := unmeteredTotal(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite)
+		counted := meteredUsage(countedUsage(mw.config.bwCurrentUsed, mw.config.bwUploadUsed, mw.config.CapCounts), unmetered)
+
+		// find the number of days since the billing cycle started (excluding today)
+		hoursSinceMonthStart := time.Since(start).Hours()
+		totalDaysInMonth := cycleDays(start)
 		gbPerDay := bwLimitGBs / totalDaysInMonth
 		gbAllowedSoFar := math.Round(gbPerDay*(hoursSinceMonthStart/24)*100) / 100 // gets number to 2 decimals
-		gbLeftToUse := bwLimitGBs - mw.config.bwCurrentUsed
+		gbLeftToUse := bwLimitGBs - counted
 		daysLeftInMonth := totalDaysInMonth - (hoursSinceMonthStart / 24)
 		mw.config.gbPerDayLeft = lower(gbLeftToUse/daysLeftInMonth, gbLeftToUse)
-		bwDifferential := gbAllowedSoFar - mw.config.bwCurrentUsed
+		bwDifferential := gbAllowedSoFar - counted
 
 		output := fmt.Sprintf("Fractional days left in month:     %.3f               (Days this month:  %d)\r\n",
 			daysLeftInMonth, int(totalDaysInMonth))
 		output += fmt.Sprintf("Bandwidth allowed up to today:  %.2f GB    (Difference from used / Left: %.2f / %d GB)\r\n",
 			gbAllowedSoFar, bwDifferential, int(gbLeftToUse))
 		output += fmt.Sprintf("Bandwidth per day remaining:    %.2f GB       (Daily average:  %.2f GB)\r\n", mw.config.gbPerDayLeft, gbPerDay)
+		if mw.config.bwUploadUsed > 0 || mw.config.CapCounts == capCountsDownload {
+			counts := "both directions"
+			if mw.config.CapCounts == capCountsDownload {
+				counts = "download only"
+			}
+			output += fmt.Sprintf("Download / upload used:         %.0f / %.0f GB    (Cap counts %s)\r\n",
+				mw.config.bwCurrentUsed-mw.config.bwUploadUsed, mw.config.bwUploadUsed, counts)
+		}
+		if unmetered > 0 || !mw.config.Unmetered.isEmpty() {
+			output += fmt.Sprintf("Metered / unmetered used:       %.0f / %.2f GB\r\n", counted, unmetered)
+		}
+		if banked > 0 || mw.config.Rollover.isSet() {
+			output += fmt.Sprintf("Banked data:                    %.2f GB    (Cap this cycle: %.0f GB)\r\n", banked, bwLimitGBs)
+		}
+		courtesyLeft := mw.courtesyLeft(start)
+		projected := projectedUsage(counted, hoursSinceMonthStart/24, totalDaysInMonth)
+		status := usageSeverity(counted, projected, bwLimitGBs, courtesyLeft)
+		output += fmt.Sprintf("Status:                         %s\r\n", status)
+		zap.L().Debug("calculated", zap.String("profile", mw.profile), zap.Float64("used", mw.config.bwCurrentUsed),
+			zap.Float64("upload", mw.config.bwUploadUsed), zap.Float64("counted", counted), zap.Float64("unmetered", unmetered),
+			zap.Float64("cap", bwLimitGBs), zap.Float64("banked", banked), zap.Float64("gbPerDayLeft", mw.config.gbPerDayLeft),
+			zap.Float64("daysLeft", daysLeftInMonth), zap.Float64("differential", bwDifferential), zap.Stringer("status", status))
+		mw.advice = adviseBudget(mw.config.gbPerDayLeft, bwDifferential, gbPerDay, daysLeftInMonth, hoursSinceMonthStart/24, counted, bwLimitGBs)
+		output += formatAdvice(mw.advice)
+		if mw.config.Pricing.isSet() {
+			output += formatCostEstimate(mw.config.Pricing.estimate(counted, hoursSinceMonthStart/24, totalDaysInMonth, bwLimitGBs, courtesyLeft))
+		}
+		if table := mw.deviceTable(); table != "" {
+			output += "\r\n" + table
+		}
 
-		return output
+		return output, nil
 	}
 }
 
+// Shows the calculations in the result box, returning the error if the readings were rejected
+func (mw *MainWin) showResults() error {
+	output, err := mw.calculateBandwidth()
+	mw.resultMsgBox.SetText(output)
+
+	return err
+}
+
 // Attempts to read last known values of program from registry (stored from last run)
-func (mw *MainWin) getRegKeyValues() registry.Key {
+func (mw *MainWin) getRegKeyValues() (registry.Key, error) {
 	// attempt to create key (won't delete if existing)
 	k, exists, err := registry.CreateKey(registry.CURRENT_USER, regKeyBranch, registry.QUERY_VALUE|registry.SET_VALUE)
 	if err != nil {
-		log.Fatalf("Error creating registry key, exiting program")
+		return k, &storeError{Store: "registry", Op: "create", Key: regKeyBranch, Err: err}
 	}
 	if exists {
-		log.Println("Registry key already existed")
+		zap.L().Debug("registry key already existed", zap.String("key", regKeyBranch))
 	}
 
-	return k
+	return k, nil
 }
 
 // Attempts to write a value to a registry string
-func (mw *MainWin) setSingleRegKeyValue(regStr, regValue string) {
+func (mw *MainWin) setSingleRegKeyValue(regStr, regValue string) error {
+	if mw.key == nil {
+		return &storeError{Store: "registry", Op: "write", Key: regStr, Err: errors.New("registry key is not open")}
+	}
+	started := time.Now()
 	err := mw.key.SetStringValue(regStr, regValue)
+	logStoreOp("registry", "write", regStr, started, err, zap.String("value", regValue))
 	if err != nil {
-		walk.MsgBox(nil, "Fatal Error", "Error writing registry value: "+regStr+" exiting program", walk.MsgBoxIconError)
-		log.Fatalf("Error writing registry value %s, exiting program", regStr)
+		return &storeError{Store: "registry", Op: "write", Key: regStr, Err: err}
 	}
+
+	return nil
 }
 
-// Get a string value from the registry
-func (mw *MainWin) GetRegStringValue(regStr string) string {
+// Get a string value from the registry, a value that was never written comes back empty
+func (mw *MainWin) GetRegStringValue(regStr string) (string, error) {
+	if mw.key == nil {
+		return "", &storeError{Store: "registry", Op: "read", Key: regStr, Err: errors.New("registry key is not open")}
+	}
+	started := time.Now()
 	value, _, err := mw.key.GetStringValue(regStr)
+	if errors.Is(err, registry.ErrNotExist) {
+		return "", nil
+	}
+	logStoreOp("registry", "read", regStr, started, err)
 	if err != nil {
-		walk.MsgBox(nil, "Fatal Error", "Error reading registry value: "+regStr+" exiting program", walk.MsgBoxIconError)
-		log.Fatalf("Error reading registry value %s, exiting program", regStr)
+		return "", &storeError{Store: "registry", Op: "read", Key: regStr, Err: err}
 	}
 
-	return value
+	return value, nil
 }
 
-// Delete all daily data if we are in new month
-func (mw *MainWin) deleteIfNewMonth() {
+// Delete all daily data if we are in a new billing cycle, returns true if it did. Cycles are
+// stored by the month they started in. Any banked data is carried over in the same transaction
+func (mw *MainWin) deleteIfNewMonth() (bool, error) {
 	dbMonth, _ := strconv.ParseInt(string(mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue4]), 10, 64)
-	if dbMonth != int64(time.Now().Month()) {
+	if dbMonth != int64(cycleStart(time.Now(), mw.config.CycleStartDay).Month()) {
 		// Have a msg box here notifying the user of deleting keys
-		walk.MsgBox(nil, "Info", "New month, will delete all daily keys now", walk.MsgBoxIconInformation)
-
-		// If key for the first day of month exists, we should assume all days do
-		// and delete all 31 days one by one (silent error for days that don't exist)
-		dayOfMonthSubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue3
-		if _, ok := mw.config.dbValues[dayOfMonthSubkey+"/01"]; ok {
-			for day := 1; day <= 31; day++ {
-				var strDay string
-				if day < 10 {
-					strDay = "0" + fmt.Sprint(day)
-				} else {
-					strDay = fmt.Sprint(day)
-				}
+		walk.MsgBox(nil, "Info", "New month, will archive and delete all daily keys now", walk.MsgBoxIconInformation)
+
+		// keep a copy of the finished month so it can be compared against later cycles, and
+		// delete every daily key with one prefix delete in the same transaction so we never
+		// end up with days deleted but not archived (or the other way around)
+		dayOfMonthSubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue3 + "/"
+		devicesSubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue8 + "/"
+		usedByDaySubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue10 + "/"
+		uploadByDaySubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue11 + "/"
+		unmeteredSubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue12 + "/"
+		archive, deviceArchive := mw.archiveMonth(dbMonth)
+		deletes := []string{dayOfMonthSubkey, devicesSubkey, usedByDaySubkey, uploadByDaySubkey, unmeteredSubkey}
+		if finished := finishedMonth(dbMonth, time.Now(), mw.config.CycleStartDay); finished != "" {
+			banked, expired := mw.carryOver(finished, cycleStart(time.Now(), mw.config.CycleStartDay).Format("2006-01"))
+			for k, v := range banked {
+				archive[k] = v
+			}
+			deletes = append(deletes, expired...)
+		}
 
-				myetcd.DeleteFromEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-					dayOfMonthSubkey+"/"+strDay)
+		// the audit entry covers the device days too, so undoing it takes their copies back out
+		copied := map[string]string{}
+		for _, puts := range []map[string]string{archive, deviceArchive} {
+			for k, v := range puts {
+				copied[k] = v
 			}
 		}
+		if key, value, ok := mw.auditPut(auditRollover, auditChanges(mw.config.dbValues, copied, nil, deletes)); ok {
+			archive[key] = value
+		}
+
+		// there can be too many device days for one transaction, so copy those first. Should
+		// the delete then fail they are simply copied again next time
+		if _, err := mw.etcd.commitBatches(deviceArchive); err != nil {
+			return true, err
+		}
+		err := mw.etcd.commit(archive, deletes...)
+		zap.L().Info("rolled over to a new billing cycle", zap.String("profile", mw.profile), zap.Int64("finishedMonth", dbMonth),
+			zap.Int("archived", len(archive)+len(deviceArchive)), zap.Strings("deleted", deletes), zap.Error(err))
+		if err == nil {
+			mw.pruneAudit(time.Now().AddDate(0, -auditKeepMonths, 0))
+		}
+		return true, err
 	}
-}
 
-// Find whichever is the higest numbered key in the range (to find the latest day of data)
-func findMaxKey(data map[string][]byte) string {
-	highestSeen := 0
+	return false, nil
+}
 
-	for k, _ := range data {
-		intDay, _ := strconv.Atoi(k[len(k)-2:])
+// Returns the finished month's daily keys and total used copied into the history
+// branch (keyed by year and month) so they can be written before the days get deleted,
+// and separately the per device days
+func (mw *MainWin) archiveMonth(dbMonth int64) (map[string]string, map[string]string) {
+	archive := map[string]string{}
+	deviceArchive := map[string]string{}
+	finished := finishedMonth(dbMonth, time.Now(), mw.config.CycleStartDay)
+	if finished == "" { // no month recorded yet, so nothing to archive
+		return archive, deviceArchive
+	}
 
-		if intDay > highestSeen {
-			highestSeen = intDay
+	dayOfMonthSubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue3 + "/"
+	historySubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue7 + "/" + finished
+	for k, v := range mw.config.dbValues {
+		if strings.HasPrefix(k, dayOfMonthSubkey) {
+			archive[historySubkey+"/"+k[len(k)-2:]] = string(v)
 		}
 	}
-
-	// if our number is single digit add a leading zero before returning
-	if highestSeen < 10 {
-		return "0" + strconv.Itoa(highestSeen)
-	} else {
-		return strconv.Itoa(highestSeen)
+	for _, regValue := range []string{regValue1, regValue9} {
+		if used, ok := mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue]; ok {
+			archive[historySubkey+"/"+regValue] = string(used)
+		}
 	}
-}
-
-// Deletes the last day of data  we have in the graph (in case the user wants to modify or
-// recalc the last few days, they can press it a few times to delete the appropriate amount)
-func (mw *MainWin) deleteLastDaysData() {
-	dayOfMonthSubkey := mw.config.Etcd.BaseKeyToWrite + "/" + regValue3
-	data, err := myetcd.ReadFromEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints, dayOfMonthSubkey)
-	if err != nil {
-		log.Print(err.Error())
+	// the running totals, so the month's peak day can be worked out later
+	for day, used := range getDaySeries(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite+"/"+regValue10) {
+		archive[historySubkey+"/"+regValue10+"/"+day] = fmt.Sprintf("%.0f", used)
+	}
+	if unmetered := unmeteredTotal(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite); unmetered > 0 {
+		archive[historySubkey+"/"+regValue12] = fmt.Sprintf("%.3f", unmetered)
 	}
-	// print(data)
-	myetcd.DeleteFromEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints, dayOfMonthSubkey+"/"+findMaxKey(data))
+	// what was banked on top of the cap, so the month is later judged against what it had
+	lots := getBankedLots(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite, finished, mw.config.Rollover)
+	if banked := bankedTotal(lots); banked > 0 {
+		archive[historySubkey+"/"+regValue13] = fmt.Sprintf("%.3f", banked)
+	}
+	for device, days := range getDeviceSeries(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite) {
+		for day, gb := range days {
+			deviceArchive[historySubkey+"/"+regValue8+"/"+device+"/"+day] = fmt.Sprintf("%.3f", gb)
+		}
+	}
+
+	return archive, deviceArchive
 }
 
-// Things to perform before showing GUI
-func (mw *MainWin) getConfigAndDBValues(exePath string) {
+// Things to perform before showing GUI. Errors are returned rather than exiting, and if etcd
+// can't be used we carry on with the registry
+func (mw *MainWin) getConfigAndDBValues() error {
+	wasUsingEtcd := mw.useEtcd
 	mw.useEtcd = false
-	err := mysupport.GetConfigContentsFromYaml(exePath, &mw.config)
-	if err != nil {
-		log.Fatal(err)
+	previous := mw.config.Etcd
+	if err := mw.config.load(mw.configPath, mw.overrides); err != nil {
+		return err
+	}
+	profile, err := mw.config.useProfile(mw.profile)
+	if err != nil { // the profile we were on has been removed from config.yml
+		profile, _ = mw.config.useProfile("")
+	}
+	mw.profile = profile
+	// keep the connection between reloads unless the settings used to make it have changed
+	settingsChanged := connectionSettingsChanged(previous, mw.config.Etcd)
+	if settingsChanged {
+		mw.etcd.close()
+		mw.etcd = nil
 	}
 
-	for _, address := range mw.config.Etcd.Endpoints {
-		ipAndPort := strings.Split(address, ":")
-		if mw.testSockConnect(ipAndPort[0], ipAndPort[1]) { // etcd exists lets use that for settings
-			mw.useEtcd = true
-
-			// if the cert path doesnt exist
-			if _, err = os.Stat(mw.config.Etcd.CertPath); errors.Is(err, os.ErrNotExist) {
-				walk.MsgBox(nil, "Fatal Error", "Fatal: "+err.Error(), walk.MsgBoxIconError)
+	var etcdErr error
+	if errs := mw.config.validate(); len(errs) > 0 {
+		etcdErr = &storeError{Store: "config", Op: "check", Key: mw.configPath, Err: errors.Join(errs...)}
+	} else if mw.etcdConfigured() {
+		if mw.etcd == nil {
+			// probe every endpoint and connect to the healthy ones fastest first, the client
+			// fails over between them in that order. While offline the probing is left to the
+			// background check, so redraws don't wait out the timeout
+			endpoints := healthyEndpoints(mw.health)
+			if !mw.degraded || settingsChanged {
+				endpoints, etcdErr = mw.checkEndpointHealth()
 			}
-
-			mw.config.dbValues, err = myetcd.ReadFromEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints, mw.config.Etcd.BaseKeyToWrite)
-			if err != nil {
-				walk.MsgBox(nil, "Fatal Error", "Fatal: "+err.Error()+"\nPossible authentication failure", walk.MsgBoxIconError)
-				log.Fatal(err.Error())
+			if len(endpoints) > 0 {
+				if mw.etcd, etcdErr = mw.connectEtcd(endpoints); etcdErr != nil {
+					etcdErr = &storeError{Store: "etcd", Op: "connect", Err: etcdErr}
+				}
 			}
-			mw.config.bwCurrentUsed, _ = strconv.ParseFloat(string(mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue1]), 64)
-
-			mw.deleteIfNewMonth()
 		}
-		// if we have connected sucessfully to any etcd server, we don't need to connect to
-		// any others servers anymore, so just break from loop
-		if mw.useEtcd {
-			break
+		if mw.etcd != nil { // etcd exists lets use that for settings
+			mw.useEtcd = true // so the rollover and journal sync record their audit entries in etcd
+			etcdErr = mw.readEtcdValues()
+			mw.useEtcd = etcdErr == nil
 		}
 	}
+
+	if mw.useEtcd && mw.degraded { // etcd is back and the journal has been replayed
+		mw.leaveDegraded()
+	}
 	if !mw.useEtcd { // etcd doesnt appear to exist lets use registry for settings
-		walk.MsgBox(nil, "Info", "Unable to reach Etcd servers, using registry fallback", walk.MsgBoxIconInformation)
-		mw.key = new(registry.Key)
-		*mw.key = mw.getRegKeyValues()
-		mw.config.bwCurrentUsed, _ = strconv.ParseFloat(mw.GetRegStringValue(regValue1), 64)
+		mw.etcd.close()
+		mw.etcd = nil
+		if wasUsingEtcd || mw.degraded { // lost etcd mid session, keep queueing readings for it
+			if etcdErr == nil {
+				etcdErr = &storeError{Store: "etcd", Op: "connect", Err: errEtcdTimeout}
+			}
+			mw.enterDegraded(etcdErr)
+		} else if etcdErr != nil && !isUnreachable(etcdErr) {
+			walk.MsgBox(nil, "Error", etcdErr.Error()+"\n\nUsing registry fallback", walk.MsgBoxIconError)
+		} else {
+			walk.MsgBox(nil, "Info", "Unable to reach Etcd servers, using registry fallback", walk.MsgBoxIconInformation)
+			if mw.etcdConfigured() { // keep checking for it in the background
+				mw.enterDegraded(etcdErr)
+			}
+		}
+		if mw.key == nil {
+			key, err := mw.getRegKeyValues()
+			if err != nil {
+				return err
+			}
+			mw.key = &key
+		}
+		used, err := mw.GetRegStringValue(mw.regName(regValue1))
+		if err != nil {
+			return err
+		}
+		mw.config.bwCurrentUsed, _ = strconv.ParseFloat(used, 64)
+		upload, err := mw.GetRegStringValue(mw.regName(regValue9))
+		if err != nil {
+			return err
+		}
+		mw.config.bwUploadUsed, _ = strconv.ParseFloat(upload, 64)
+
+		// show the days recorded offline this month, since the registry doesn't hold any
+		if mw.etcdConfigured() {
+			entries, _ := readJournal(mw.journalPath())
+			mw.config.dbValues = overlayJournal(entries, mw.config.dbValues, mw.config.CycleStartDay, time.Now())
+		}
 	}
+
+	return nil
 }
 
-// Checks a socket connection and returns bool of if open or not
-func (mw *MainWin) testSockConnect(host string, port string) bool {
-	conn, _ := net.DialTimeout("tcp", net.JoinHostPort(host, port), 500*time.Millisecond)
-	if conn != nil {
-		defer conn.Close()
-		return true
-	} else {
-		return false
+// Reads all our values from etcd, clears out daily keys if the month has changed, then
+// syncs anything recorded in the journal while etcd was unreachable
+func (mw *MainWin) readEtcdValues() error {
+	var err error
+	mw.config.dbValues, mw.config.dbRevisions, err = mw.etcd.read(mw.config.Etcd.BaseKeyToWrite + "/")
+	if err != nil {
+		return &storeError{Store: "etcd", Op: "read", Key: mw.config.Etcd.BaseKeyToWrite, Err: err}
+	}
+	if err = mw.moveOldAuditEntries(); err != nil {
+		zap.L().Warn("audit entries not moved", zap.Error(err))
+	}
+
+	// the rollover has to happen first, so offline readings from the new month aren't archived
+	// with the old one, and offline readings from the old month can go straight to history
+	rolledOver, err := mw.deleteIfNewMonth()
+	if err != nil {
+		walk.MsgBox(nil, "Warning", "Could not clear last month's keys: "+err.Error(), walk.MsgBoxIconWarning)
+	}
+	synced, err := mw.syncJournal()
+	if err != nil {
+		return err
+	}
+	if synced > 0 {
+		zap.L().Info("synced offline values to etcd", zap.Int("values", synced))
+	}
+	if rolledOver || synced > 0 {
+		mw.config.dbValues, mw.config.dbRevisions, err = mw.etcd.read(mw.config.Etcd.BaseKeyToWrite + "/")
+		if err != nil {
+			return &storeError{Store: "etcd", Op: "read", Key: mw.config.Etcd.BaseKeyToWrite, Err: err}
+		}
 	}
+	mw.config.bwCurrentUsed, _ = strconv.ParseFloat(string(mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue1]), 64)
+	mw.config.bwUploadUsed, _ = strconv.ParseFloat(string(mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue9]), 64)
+
+	return nil
+}
+
+// Returns true if config.yml lists any etcd servers, even if none can be reached right now
+func (mw *MainWin) etcdConfigured() bool {
+	return len(mw.config.Etcd.Endpoints) > 0
 }
 
 // Simple function to add a leading zero to single digit calendar days
@@ -249,13 +417,18 @@ func getStrDayOfMonth(todaysDate int) string {
 }
 
 // This func checks if days are missing between the last day of data we have
-// and the current day, then adds bars for each day that is between them
-func addBarsToDBIfNeeded(mw *MainWin) {
+// and the current day, then adds bars for each day that is between them to the
+// values about to be written
+func addBarsToDBIfNeeded(mw *MainWin, values map[string]string) {
 	_, bars := getBarsData(mw)
 	if len(bars) > 0 {
-		barsLastLabel, _ := strconv.ParseInt(bars[len(bars)-1].Label, 10, 64)
+		// work in dates rather than day numbers, as a billing cycle can wrap into the next month
+		now := time.Now()
+		barsLastLabel, _ := strconv.Atoi(bars[len(bars)-1].Label)
+		barsLastDate := cycleDate(cycleStart(now, mw.config.CycleStartDay), barsLastLabel)
 		barsLastValue := bars[len(bars)-1].Value
-		daysLapse := time.Now().Day() - int(barsLastLabel)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		daysLapse := int(math.Round(today.Sub(barsLastDate).Hours() / 24))
 
 		if daysLapse > 1 {
 			differenceBetweenDays := mw.config.gbPerDayLeft - barsLastValue
@@ -263,44 +436,78 @@ func addBarsToDBIfNeeded(mw *MainWin) {
 			for i := 1; i < daysLapse; i++ {
 				// there are more than zero days missing since yesterday (or possible further
 				// back) appear to not be the last bars label so we should add some bars
-				barsLastLabel += 1
+				barsLastDate = barsLastDate.AddDate(0, 0, 1)
 				barsLastValue += differenceBetweenDays
-				strDayOfMonth := getStrDayOfMonth(int(barsLastLabel))
+				strDayOfMonth := getStrDayOfMonth(barsLastDate.Day())
 
-				myetcd.WriteToEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-					mw.config.Etcd.BaseKeyToWrite+"/"+regValue3+"/"+strDayOfMonth,
-					fmt.Sprintf("%.3f", barsLastValue))
+				values[mw.config.Etcd.BaseKeyToWrite+"/"+regValue3+"/"+strDayOfMonth] = fmt.Sprintf("%.3f", barsLastValue)
 			}
 		}
 	}
 }
 
-// Writes the final values before exiting program
-func (mw *MainWin) writeValuesToDB() {
-	if mw.useEtcd {
+// Writes the final values before exiting program. Whenever etcd is configured but can't be
+// reached the values (daily bars included) are also recorded in the journal, to be synced later
+func (mw *MainWin) writeValuesToDB() error {
+	if mw.useEtcd || mw.etcdConfigured() {
 		// Add leading zero to single digit days
 		strDayOfMonth := getStrDayOfMonth(time.Now().Day())
 
+		baseKey := mw.config.Etcd.BaseKeyToWrite + "/"
+		values := map[string]string{
+			baseKey + regValue1:                       fmt.Sprintf("%.0f", mw.config.bwCurrentUsed),
+			baseKey + regValue2:                       fmt.Sprintf("%.3f", mw.config.gbPerDayLeft),
+			baseKey + regValue3 + "/" + strDayOfMonth: fmt.Sprintf("%.3f", mw.config.gbPerDayLeft),
+			baseKey + regValue4:                       fmt.Sprintf("%d", int(cycleStart(time.Now(), mw.config.CycleStartDay).Month())),
+			baseKey + regValue5:                       fmt.Sprintf("%.3f", mw.config.bwMin),
+			baseKey + regValue6:                       fmt.Sprintf("%.3f", mw.config.bwMax),
+			baseKey + regValue9:                       fmt.Sprintf("%.0f", mw.config.bwUploadUsed),
+		}
+		// the running totals at the end of today, so each day's download and upload can be worked out
+		values[baseKey+regValue10+"/"+strDayOfMonth] = fmt.Sprintf("%.0f", mw.config.bwCurrentUsed)
+		values[baseKey+regValue11+"/"+strDayOfMonth] = fmt.Sprintf("%.0f", mw.config.bwUploadUsed)
+
 		// check if there are more than zero days of data missing from chart, and if so
-		// extrapolate to create the remaining bars and write them to DB
-		addBarsToDBIfNeeded(mw)
-
-		// then write to etcd
-		myetcd.WriteToEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-			mw.config.Etcd.BaseKeyToWrite+"/"+regValue1, fmt.Sprintf("%.0f", mw.config.bwCurrentUsed))
-		myetcd.WriteToEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-			mw.config.Etcd.BaseKeyToWrite+"/"+regValue2, fmt.Sprintf("%.3f", mw.config.gbPerDayLeft))
-		myetcd.WriteToEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-			mw.config.Etcd.BaseKeyToWrite+"/"+regValue3+"/"+strDayOfMonth, fmt.Sprintf("%.3f", mw.config.gbPerDayLeft))
-		myetcd.WriteToEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-			mw.config.Etcd.BaseKeyToWrite+"/"+regValue4, fmt.Sprintf("%d", int(time.Now().Month())))
-		myetcd.WriteToEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-			mw.config.Etcd.BaseKeyToWrite+"/"+regValue5, fmt.Sprintf("%.3f", mw.config.bwMin))
-		myetcd.WriteToEtcd(&mw.config.Etcd.CertPath, &mw.config.Etcd.Endpoints,
-			mw.config.Etcd.BaseKeyToWrite+"/"+regValue6, fmt.Sprintf("%.3f", mw.config.bwMax))
-	} else {
-		// or write to registry if no etcd
-		mw.setSingleRegKeyValue(regValue1, fmt.Sprintf("%.0f", mw.config.bwCurrentUsed))
-		mw.setSingleRegKeyValue(regValue2, fmt.Sprintf("%.3f", mw.config.gbPerDayLeft))
+		// extrapolate to create the remaining bars
+		interpolated := map[string]string{}
+		addBarsToDBIfNeeded(mw, interpolated)
+		for k, v := range interpolated {
+			values[k] = v
+		}
+		if len(interpolated) > 0 {
+			zap.L().Info("filled in missing days", zap.Strings("keys", sortedStringKeys(interpolated)))
+		}
+
+		// then write everything to etcd in one transaction so a calculation is all or nothing,
+		// checking nobody else wrote to the same keys since we read them
+		var err error
+		if mw.useEtcd {
+			err = mw.commitCheckingConflicts(values, interpolated)
+			if isUnreachable(err) {
+				mw.enterDegraded(err)
+			}
+		}
+		if mw.useEtcd {
+			return err
+		}
+		if err = mw.queueValues(values, interpolated); err != nil {
+			return err
+		}
+		mw.updateStatus()
+		if mw.key == nil { // etcd went away since the values were read, there's no registry copy yet
+			return nil
+		}
 	}
+
+	// or write to registry if no etcd (and keep it up to date while etcd is unreachable)
+	err := mw.setRegValues(auditSave, map[string]string{
+		mw.regName(regValue1): fmt.Sprintf("%.0f", mw.config.bwCurrentUsed),
+		mw.regName(regValue9): fmt.Sprintf("%.0f", mw.config.bwUploadUsed),
+		mw.regName(regValue2): fmt.Sprintf("%.3f", mw.config.gbPerDayLeft),
+	})
+	if err == nil { // the registry has no rollover, so old entries are dropped as new ones come
+		mw.pruneAudit(time.Now().AddDate(0, -auditKeepMonths, 0))
+	}
+
+	return err
 }
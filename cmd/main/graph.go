@@ -1,8 +1,8 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 
@@ -12,19 +12,33 @@ import (
 
 const graphFilename = "graph.png"
 
-// iterates through all possible numbers 1 to 31 to see if the exist in the DB
+// What the chart shows, in the order they are listed in the chart picker
+const (
+	chartDayLeft = iota
+	chartDevices
+	chartDirections
+)
+
+var chartModes = []string{"GB/day left", "Devices", "Download/upload"}
+
+// iterates through all possible days 1 to 31 to see if the exist in the DB
 // to determine if there is bar data for each day in the map structure
 func getBarsData(mw *MainWin) ([]float64, []chart.Value) {
+	return getBarsDataUnder(mw, mw.config.Etcd.BaseKeyToWrite+"/"+regValue3)
+}
+
+// Same as getBarsData but for any subkey holding day numbered values (like an archived month)
+func getBarsDataUnder(mw *MainWin, subkey string) ([]float64, []chart.Value) {
 	allValues := []float64{}
 	bars := []chart.Value{}
 
-	for i := 1; i < 32; i++ {
+	for _, i := range cycleDayOrder(mw.config.CycleStartDay) { // in the order the billing cycle runs
 		strNum := fmt.Sprint(i)
 		if i < 10 {
 			strNum = "0" + fmt.Sprint(i)
 		}
-		val, ok := mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue3+"/"+strNum]
-		if ok { // if we hit values that doesnt exist we have no more in the map (since they will always be ordered)
+		val, ok := mw.config.dbValues[subkey+"/"+strNum]
+		if ok {
 			fVal, _ := strconv.ParseFloat(string(val[:]), 64)
 			allValues = append(allValues, fVal)
 			bars = append(bars, chart.Value{Label: strNum, Value: fVal})
@@ -85,9 +99,27 @@ func setGraphUpperLowerExtents(mw *MainWin, min, max float64) {
 	}
 }
 
-// Creates the bar graph png file
-func (mw *MainWin) makeChart() {
-	mw.getConfigAndDBValues(mw.exePath + "\\config.yml")
+// Creates the bar graph png file. If the values couldn't be reloaded the chart is still drawn
+// from what we already had, and the load error is returned
+func (mw *MainWin) makeChart() error {
+	loadErr := mw.getConfigAndDBValues()
+
+	// stack up what each device, or each direction, used per day instead
+	var stacked []chart.StackedBar
+	baseKey := mw.config.Etcd.BaseKeyToWrite
+	switch mw.chartMode {
+	case chartDevices:
+		stacked = deviceStackedBars(getDeviceSeries(mw.config.dbValues, baseKey), mw.config.CycleStartDay)
+	case chartDirections:
+		stacked = directionStackedBars(getDaySeries(mw.config.dbValues, baseKey+"/"+regValue10),
+			getDaySeries(mw.config.dbValues, baseKey+"/"+regValue11), mw.config.CycleStartDay)
+	}
+	if len(stacked) > 0 {
+		if err := renderStackedChart(stacked); err != nil {
+			return err
+		}
+		return loadErr
+	}
 
 	allValues, bars := getBarsData(mw)
 
@@ -158,31 +190,42 @@ func (mw *MainWin) makeChart() {
 		// open the file we will write too
 		file, err := os.OpenFile(graphFilename, os.O_WRONLY|os.O_CREATE, 0600)
 		if err != nil {
-			log.Fatal("Chart file could not be opened")
+			return &storeError{Store: "chart", Op: "open", Key: graphFilename, Err: err}
 		}
 		defer file.Close()
 
 		if err = graph.Render(chart.PNG, file); err != nil {
-			log.Fatal("Chart could not be rendered")
+			return &storeError{Store: "chart", Op: "render", Key: graphFilename, Err: err}
 		}
 	}
+
+	return loadErr
 }
 
 // Gets the image from file and puts into walk.image struct so we can use
-func (mw *MainWin) getImageFromFile() walk.Image {
+func (mw *MainWin) getImageFromFile() (walk.Image, error) {
 	img, err := walk.NewImageFromFileForDPI(graphFilename, 600)
 	if err != nil {
-		log.Fatal("Cannot load new image")
+		return nil, &storeError{Store: "chart", Op: "load image", Key: graphFilename, Err: err}
 	}
 
-	return img
+	return img, nil
 }
 
 // Sets (and resets) the widget that holds the graph so we can refresh it in program
-func (mw *MainWin) refreshImage() {
+func (mw *MainWin) refreshImage() error {
+	// nothing has been drawn yet (no daily data), so leave the empty widget
+	if _, err := os.Stat(graphFilename); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	img, err := mw.getImageFromFile()
+	if err != nil {
+		return err
+	}
+
 	// create new image from recalculations
 	if imageView, err := walk.NewImageView(mw.graphImage.Parent()); err == nil {
-		imageView.SetImage(mw.getImageFromFile())
+		imageView.SetImage(img)
 		imageView.SetMinMaxSize(walk.Size{initialWinWidth, graphImgHeight},
 			walk.Size{initialWinWidth, graphImgHeight})
 		imageView.SetMargin(4)
@@ -192,6 +235,20 @@ func (mw *MainWin) refreshImage() {
 		mw.graphImage.Dispose()
 		mw.graphImage = imageView
 	} else {
-		log.Fatal("Cannot create new imageview")
+		return &storeError{Store: "chart", Op: "create image view", Err: err}
+	}
+
+	return nil
+}
+
+// Reloads the values and redraws the chart, telling the user about anything that failed
+// rather than exiting
+func (mw *MainWin) redrawChart() {
+	if err := mw.makeChart(); err != nil {
+		mw.showError("Could not reload values", err)
+	}
+	mw.updateStatus()
+	if err := mw.refreshImage(); err != nil {
+		mw.showError("Could not show chart", err)
 	}
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// Result of probing a single etcd endpoint
+type endpointHealth struct {
+	endpoint string
+	healthy  bool
+	latency  time.Duration
+	memberID uint64
+	err      error
+}
+
+// Asks one endpoint for its status over TLS, timing how long it took to answer
+func probeEndpoint(endpoint string, clientConfig clientv3.Config) endpointHealth {
+	health := endpointHealth{endpoint: endpoint}
+	timeout := clientConfig.DialTimeout
+
+	clientConfig.Endpoints = []string{endpoint}
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		health.err = fmt.Errorf("%w: %v", errEtcdTimeout, err)
+		return health
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := client.Status(ctx, endpoint)
+	if err != nil {
+		if isRetryable(err) {
+			err = fmt.Errorf("%w: %v", errEtcdTimeout, err)
+		}
+		health.err = err
+		return health
+	}
+	health.latency = time.Since(start)
+	health.memberID = resp.Header.MemberId
+	health.healthy = resp.Leader != 0 // a member without a leader can't serve reads or writes
+	if !health.healthy {
+		health.err = fmt.Errorf("%w: member has no leader", errEtcdTimeout)
+	}
+
+	return health
+}
+
+// Probes every endpoint at once and returns them healthy first, fastest first, with the
+// unhealthy ones after in config order
+func probeEndpoints(endpoints []string, clientConfig clientv3.Config) []endpointHealth {
+	results := make([]endpointHealth, len(endpoints))
+
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			results[i] = probeEndpoint(endpoint, clientConfig)
+		}(i, endpoint)
+	}
+	wg.Wait()
+	sortByHealth(results)
+
+	return results
+}
+
+// Orders healthy endpoints by latency ahead of unhealthy ones, keeping config order for ties
+func sortByHealth(results []endpointHealth) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].healthy != results[j].healthy {
+			return results[i].healthy
+		}
+		return results[i].healthy && results[i].latency < results[j].latency
+	})
+}
+
+// Returns the healthy endpoints, fastest first
+func healthyEndpoints(results []endpointHealth) []string {
+	endpoints := []string{}
+	for _, health := range results {
+		if health.healthy {
+			endpoints = append(endpoints, health.endpoint)
+		}
+	}
+
+	return endpoints
+}
+
+// Marks every endpoint down with the error, for when etcd stopped answering since the last probe
+func markUnhealthy(results []endpointHealth, err error) {
+	for i := range results {
+		results[i].healthy, results[i].err = false, err
+	}
+}
+
+// Returns the first error from an unhealthy endpoint that wasn't just a timeout (like a bad
+// cert or auth failure), or failing that the first timeout
+func healthError(results []endpointHealth) error {
+	var firstErr error
+	for _, health := range results {
+		if health.err == nil {
+			continue
+		}
+		if !isUnreachable(health.err) {
+			return health.err
+		}
+		if firstErr == nil {
+			firstErr = health.err
+		}
+	}
+
+	return firstErr
+}
+
+// Returns a line per endpoint describing how it answered the last probe
+func formatHealth(results []endpointHealth) string {
+	lines := []string{}
+	for _, health := range results {
+		if health.healthy {
+			lines = append(lines, fmt.Sprintf("%s  ok  member %x  %d ms",
+				health.endpoint, health.memberID, health.latency.Milliseconds()))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s  down  %v", health.endpoint, health.err))
+		}
+	}
+
+	return strings.Join(lines, "\r\n")
+}
+
+// Returns which endpoint the member id belongs to, going by the last probe
+func endpointForMember(results []endpointHealth, memberID uint64) string {
+	for _, health := range results {
+		if health.memberID == memberID && memberID != 0 {
+			return health.endpoint
+		}
+	}
+
+	return "unknown endpoint"
+}
+
+// Probes the configured endpoints, remembering the results for the status indicator
+func (mw *MainWin) checkEndpointHealth() ([]string, error) {
+	clientConfig, err := etcdClientConfig(mw.config.Etcd, mw.etcdTimeout())
+	if err != nil {
+		return nil, &storeError{Store: "etcd", Op: "load certs", Key: mw.config.Etcd.CertPath, Err: err}
+	}
+	mw.health = probeEndpoints(mw.config.Etcd.Endpoints, clientConfig)
+
+	endpoints := healthyEndpoints(mw.health)
+	if len(endpoints) == 0 {
+		if err = healthError(mw.health); err != nil {
+			return nil, &storeError{Store: "etcd", Op: "health check", Err: err}
+		}
+		return nil, nil
+	}
+
+	return endpoints, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortByHealth(t *testing.T) {
+	results := []endpointHealth{
+		{endpoint: "a:2379", err: errEtcdTimeout},
+		{endpoint: "b:2379", healthy: true, latency: 40 * time.Millisecond},
+		{endpoint: "c:2379", err: errors.New("bad certificate")},
+		{endpoint: "d:2379", healthy: true, latency: 12 * time.Millisecond},
+	}
+	sortByHealth(results)
+
+	expected := []string{"d:2379", "b:2379", "a:2379", "c:2379"}
+	order := []string{}
+	for _, health := range results {
+		order = append(order, health.endpoint)
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, order)
+	}
+	if healthy := healthyEndpoints(results); !reflect.DeepEqual(healthy, expected[:2]) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected[:2], healthy)
+	}
+	if err := healthError(results); err == nil || err.Error() != "bad certificate" {
+		t.Errorf("ERROR: Expected: %q got: %v", "bad certificate", err)
+	}
+}
+
+func TestMarkUnhealthy(t *testing.T) {
+	results := []endpointHealth{
+		{endpoint: "a:2379", healthy: true, latency: 12 * time.Millisecond},
+		{endpoint: "b:2379", err: errors.New("bad certificate")},
+	}
+	markUnhealthy(results, errEtcdTimeout)
+
+	if healthy := healthyEndpoints(results); len(healthy) != 0 {
+		t.Errorf("ERROR: Expected: no healthy endpoints got: %v", healthy)
+	}
+	for _, health := range results {
+		if !errors.Is(health.err, errEtcdTimeout) {
+			t.Errorf("ERROR: Expected: %v for %s got: %v", errEtcdTimeout, health.endpoint, health.err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const journalFilename = "journal.jsonl"
+
+// One set of values that couldn't be written to etcd, stored one json object per line
+type journalEntry struct {
+	Time         time.Time         `json:"time"`
+	Values       map[string]string `json:"values"`                 // full etcd key to value
+	Interpolated []string          `json:"interpolated,omitempty"` // days in values that were only guessed
+}
+
+// Appends the entry to the end of the journal, creating the file if needed
+func appendJournal(path string, entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+
+	return err
+}
+
+// Returns every entry in the journal, oldest first (no file means nothing is queued)
+func readJournal(path string) ([]journalEntry, error) {
+	entries := []journalEntry{}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return entries, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Deletes the journal once everything in it has been written to etcd
+func clearJournal(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (mw *MainWin) journalPath() string {
+	return filepath.Join(mw.dataDir, journalFilename)
+}
+
+// Works out what to write to etcd from the journal, given what etcd currently holds. Later
+// entries win over earlier ones. Readings from a billing cycle that has since finished go
+// straight into that cycle's history. For this cycle a reading only replaces etcd's if it is
+// higher (usage only goes up within a cycle), and interpolated days never replace a day etcd
+// already has
+func reconcileJournal(entries []journalEntry, current map[string][]byte, baseKey string, startDay int, now time.Time) map[string]string {
+	writes := map[string]string{}
+	thisMonth := cycleStart(now, startDay).Format("2006-01")
+	etcdMonth, _ := strconv.Atoi(string(current[baseKey+"/"+regValue4]))
+	highestUsed := -1.0
+	if etcdMonth == int(cycleStart(now, startDay).Month()) {
+		highestUsed, _ = strconv.ParseFloat(string(current[baseKey+"/"+regValue1]), 64)
+	}
+	// returns true if the value is a higher reading than what is already stored or queued for key
+	isHigher := func(key, value string) bool {
+		newUsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		oldValue, ok := writes[key]
+		if !ok {
+			oldValue = string(current[key])
+		}
+		oldUsed, err := strconv.ParseFloat(oldValue, 64)
+		return err != nil || newUsed >= oldUsed
+	}
+
+	for _, entry := range entries {
+		month := cycleStart(entry.Time, startDay).Format("2006-01")
+		interpolated := map[string]bool{}
+		for _, k := range entry.Interpolated {
+			interpolated[k] = true
+		}
+
+		for k, v := range entry.Values {
+			if !strings.HasPrefix(k, baseKey+"/") {
+				continue
+			}
+			subkey := k[len(baseKey)+1:]
+
+			if month != thisMonth { // finished month recorded offline
+				historySubkey := baseKey + "/" + regValue7 + "/" + month + "/"
+				switch {
+				case subkey == regValue1, subkey == regValue9:
+					if isHigher(historySubkey+subkey, v) {
+						writes[historySubkey+subkey] = v
+					}
+				case strings.HasPrefix(subkey, regValue3+"/"):
+					if _, exists := current[historySubkey+subkey[len(regValue3)+1:]]; !interpolated[k] || !exists {
+						writes[historySubkey+subkey[len(regValue3)+1:]] = v
+					}
+				}
+				continue
+			}
+
+			switch {
+			case subkey == regValue1:
+				if used, err := strconv.ParseFloat(v, 64); err == nil && used >= highestUsed {
+					highestUsed = used
+					writes[k] = v
+					writes[baseKey+"/"+regValue2] = entry.Values[baseKey+"/"+regValue2]
+					writes[baseKey+"/"+regValue9] = entry.Values[baseKey+"/"+regValue9]
+				}
+			case subkey == regValue2, subkey == regValue9: // go with their reading, handled above
+			case strings.HasPrefix(subkey, regValue3+"/"):
+				if _, exists := current[k]; !interpolated[k] || !exists {
+					writes[k] = v
+				}
+			case strings.HasPrefix(subkey, regValue8+"/"), strings.HasPrefix(subkey, regValue10+"/"),
+				strings.HasPrefix(subkey, regValue11+"/"), strings.HasPrefix(subkey, regValue12+"/"):
+				// device and unmetered days and the running totals only go up too
+				if isHigher(k, v) {
+					writes[k] = v
+				}
+			default:
+				writes[k] = v
+			}
+		}
+	}
+	for _, regValue := range []string{regValue2, regValue9} {
+		if writes[baseKey+"/"+regValue] == "" {
+			delete(writes, baseKey+"/"+regValue)
+		}
+	}
+
+	return writes
+}
+
+// Returns the values with this cycle's journal entries laid over the top, so days recorded
+// offline still show on the chart
+func overlayJournal(entries []journalEntry, values map[string][]byte, startDay int, now time.Time) map[string][]byte {
+	overlaid := map[string][]byte{}
+	for k, v := range values {
+		overlaid[k] = v
+	}
+	for _, entry := range entries {
+		if !cycleStart(entry.Time, startDay).Equal(cycleStart(now, startDay)) {
+			continue
+		}
+		for k, v := range entry.Values {
+			overlaid[k] = []byte(v)
+		}
+	}
+
+	return overlaid
+}
+
+// Keeps values that couldn't be written so they can be synced once etcd is back
+func (mw *MainWin) queueValues(values, interpolated map[string]string) error {
+	entry := journalEntry{Time: time.Now(), Values: values}
+	for k := range interpolated {
+		entry.Interpolated = append(entry.Interpolated, k)
+	}
+	sort.Strings(entry.Interpolated)
+
+	started := time.Now()
+	err := appendJournal(mw.journalPath(), entry)
+	logStoreOp("journal", "append", mw.journalPath(), started, err, zap.Strings("keys", sortedStringKeys(values)))
+	if err != nil {
+		return &storeError{Store: "journal", Op: "append", Key: mw.journalPath(), Err: err}
+	}
+
+	return nil
+}
+
+// Splits the entries into those holding values under the base key and those that don't
+// (recorded for another profile)
+func splitJournal(entries []journalEntry, baseKey string) ([]journalEntry, []journalEntry) {
+	ours, others := []journalEntry{}, []journalEntry{}
+	for _, entry := range entries {
+		isOurs := false
+		for k := range entry.Values {
+			isOurs = isOurs || strings.HasPrefix(k, baseKey+"/")
+		}
+		if isOurs {
+			ours = append(ours, entry)
+		} else {
+			others = append(others, entry)
+		}
+	}
+
+	return ours, others
+}
+
+// Reconciles everything the journal holds for the profile in use with what is in etcd, writes
+// the result and removes those entries from the journal. Returns how many values were written
+func (mw *MainWin) syncJournal() (int, error) {
+	entries, err := readJournal(mw.journalPath())
+	if err != nil {
+		return 0, &storeError{Store: "journal", Op: "read", Key: mw.journalPath(), Err: err}
+	}
+	entries, others := splitJournal(entries, mw.config.Etcd.BaseKeyToWrite)
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	writes := reconcileJournal(entries, mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite, mw.config.CycleStartDay, time.Now())
+	written, err := mw.etcd.commitBatches(writes)
+	if err != nil {
+		return written, &storeError{Store: "etcd", Op: "sync journal", Err: err}
+	}
+	if err = mw.recordAudit(auditSyncJournal, mw.config.dbValues, writes); err != nil {
+		return written, err
+	}
+	if err = clearJournal(mw.journalPath()); err != nil {
+		return written, &storeError{Store: "journal", Op: "clear", Key: mw.journalPath(), Err: err}
+	}
+	for _, entry := range others { // other profiles' entries wait until they are in use
+		if err = appendJournal(mw.journalPath(), entry); err != nil {
+			return written, &storeError{Store: "journal", Op: "append", Key: mw.journalPath(), Err: err}
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), journalFilename)
+
+	t.Run("Check missing journal is empty", func(t *testing.T) {
+		entries, err := readJournal(path)
+		if err != nil || len(entries) != 0 {
+			t.Errorf("ERROR: Expected: no entries got: %v, %v", entries, err)
+		}
+	})
+
+	t.Run("Check entries come back in order", func(t *testing.T) {
+		first := journalEntry{Time: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), Values: map[string]string{"/bw/bwCurrentUsed": "10"}}
+		second := journalEntry{Time: time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC), Values: map[string]string{"/bw/bwCurrentUsed": "52"}}
+		for _, entry := range []journalEntry{first, second} {
+			if err := appendJournal(path, entry); err != nil {
+				t.Fatalf("ERROR: Expected: no error got: %v", err)
+			}
+		}
+		entries, err := readJournal(path)
+		if err != nil {
+			t.Fatalf("ERROR: Expected: no error got: %v", err)
+		}
+		if !reflect.DeepEqual(entries, []journalEntry{first, second}) {
+			t.Errorf("ERROR: Expected: %v got: %v", []journalEntry{first, second}, entries)
+		}
+	})
+
+	t.Run("Check cleared journal is empty", func(t *testing.T) {
+		if err := clearJournal(path); err != nil {
+			t.Fatalf("ERROR: Expected: no error got: %v", err)
+		}
+		if entries, _ := readJournal(path); len(entries) != 0 {
+			t.Errorf("ERROR: Expected: no entries got: %v", entries)
+		}
+	})
+}
+
+func TestReconcileJournal(t *testing.T) {
+	now := time.Date(2024, 3, 20, 12, 0, 0, 0, time.Local)
+	current := map[string][]byte{
+		"/bw/bwCurrentUsed":     []byte("500"),
+		"/bw/bwPerDayRemaining": []byte("40.000"),
+		"/bw/monthOfYear":       []byte("3"),
+		"/bw/dayOfMonth/17":     []byte("41.000"),
+		"/bw/devices/tv/20":     []byte("12.000"),
+	}
+
+	tests := []struct {
+		name     string
+		entries  []journalEntry
+		expected map[string]string
+	}{
+		{
+			"Check higher offline reading wins",
+			[]journalEntry{{Time: now, Values: map[string]string{"/bw/bwCurrentUsed": "560", "/bw/bwPerDayRemaining": "39.000",
+				"/bw/dayOfMonth/20": "39.000", "/bw/monthOfYear": "3"}}},
+			map[string]string{"/bw/bwCurrentUsed": "560", "/bw/bwPerDayRemaining": "39.000", "/bw/dayOfMonth/20": "39.000", "/bw/monthOfYear": "3"},
+		},
+		{
+			"Check lower offline reading loses but its day is kept",
+			[]journalEntry{{Time: now, Values: map[string]string{"/bw/bwCurrentUsed": "450", "/bw/bwPerDayRemaining": "43.000",
+				"/bw/dayOfMonth/18": "43.000"}}},
+			map[string]string{"/bw/dayOfMonth/18": "43.000"},
+		},
+		{
+			"Check interpolated day doesn't replace a stored day",
+			[]journalEntry{{Time: now, Values: map[string]string{"/bw/dayOfMonth/17": "40.500", "/bw/dayOfMonth/18": "40.200"},
+				Interpolated: []string{"/bw/dayOfMonth/17", "/bw/dayOfMonth/18"}}},
+			map[string]string{"/bw/dayOfMonth/18": "40.200"},
+		},
+		{
+			"Check upload goes with its reading",
+			[]journalEntry{
+				{Time: now, Values: map[string]string{"/bw/bwCurrentUsed": "520", "/bw/bwUploadUsed": "90", "/bw/usedByDay/20": "520"}},
+				{Time: now, Values: map[string]string{"/bw/bwCurrentUsed": "510", "/bw/bwUploadUsed": "95", "/bw/usedByDay/20": "510"}},
+			},
+			map[string]string{"/bw/bwCurrentUsed": "520", "/bw/bwUploadUsed": "90", "/bw/usedByDay/20": "520"},
+		},
+		{
+			"Check device day only goes up",
+			[]journalEntry{{Time: now, Values: map[string]string{"/bw/devices/tv/20": "10.000", "/bw/devices/laptop/20": "2.500"}}},
+			map[string]string{"/bw/devices/laptop/20": "2.500"},
+		},
+		{
+			"Check last month's readings go to history",
+			[]journalEntry{
+				{Time: time.Date(2024, 2, 28, 9, 0, 0, 0, time.Local), Values: map[string]string{"/bw/bwCurrentUsed": "1100", "/bw/dayOfMonth/28": "30.000"}},
+				{Time: time.Date(2024, 2, 29, 9, 0, 0, 0, time.Local), Values: map[string]string{"/bw/bwCurrentUsed": "1150", "/bw/dayOfMonth/29": "79.000"}},
+			},
+			map[string]string{"/bw/history/2024-02/bwCurrentUsed": "1150", "/bw/history/2024-02/28": "30.000", "/bw/history/2024-02/29": "79.000"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := reconcileJournal(test.entries, current, "/bw", 1, now)
+			if !reflect.DeepEqual(results, test.expected) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, results)
+			}
+		})
+	}
+}
+
+func TestSplitJournal(t *testing.T) {
+	home := journalEntry{Values: map[string]string{"/bw/home/bwCurrentUsed": "10"}}
+	homeTwo := journalEntry{Values: map[string]string{"/bw/home-two/bwCurrentUsed": "20"}}
+	cabin := journalEntry{Values: map[string]string{"/bw/cabin/bwCurrentUsed": "30"}}
+
+	ours, others := splitJournal([]journalEntry{home, homeTwo, cabin}, "/bw/home")
+	if !reflect.DeepEqual(ours, []journalEntry{home}) || !reflect.DeepEqual(others, []journalEntry{homeTwo, cabin}) {
+		t.Errorf("ERROR: Expected: %v, %v got: %v, %v", []journalEntry{home}, []journalEntry{homeTwo, cabin}, ours, others)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// How long to wait for more changes before refreshing, so a burst of writes only redraws once
+const watchSettleTime = 500 * time.Millisecond
+
+// Watches the base key on its own connection (the main one is replaced on every reload) and
+// refreshes the result panel and chart whenever another client or collector records usage
+func (mw *MainWin) startWatching() {
+	if !mw.useEtcd {
+		return
+	}
+	store, err := mw.connectEtcd(healthyEndpoints(mw.health))
+	if err != nil {
+		zap.L().Warn("not watching for updates", zap.Error(err))
+		return
+	}
+
+	var mu sync.Mutex
+	var timer *time.Timer
+	stop := store.watch(mw.config.Etcd.BaseKeyToWrite+"/", func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(watchSettleTime, func() {
+			mw.Synchronize(mw.refreshFromDB)
+		})
+	})
+
+	mw.stopWatching = func() {
+		stop()
+		store.close()
+	}
+}
+
+// Reloads everything from the db and updates the gui, leaving the bandwidth box alone if
+// the user has typed something that hasn't been saved yet
+func (mw *MainWin) refreshFromDB() {
+	if mw.IsDisposed() {
+		return
+	}
+	unedited := !mw.readingsEdited()
+
+	mw.redrawChart()
+	if unedited {
+		mw.showReadings()
+	}
+	if err := mw.showResults(); err != nil {
+		return
+	}
+	mw.enforceBudgetInBackground()
+}
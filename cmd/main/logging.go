@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Everything is logged as JSON lines to a file in the data dir, since a -H=windowsgui build has
+// nowhere to print to. The file is rotated once it gets too big, keeping a few old ones
+
+const (
+	logFilename     = "CalcBandwidth.log"
+	logMaxBytes     = 5 << 20
+	logBackups      = 3
+	defaultLogLevel = "info"
+)
+
+// Level of the logger, changed whenever the config is loaded
+var logLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+// A log file that moves itself aside to .1, .2 and so on once it reaches maxBytes
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	file     *os.File
+	size     int64
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+
+	return nil
+}
+
+// Closes the file, shifts the backups along dropping the oldest, and starts a new file
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	for n := r.backups - 1; n >= 1; n-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, n), fmt.Sprintf("%s.%d", r.path, n+1))
+	}
+	if r.backups > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *rotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Sync()
+}
+
+// Starts logging to the file in the data dir, standard library log output included, and
+// returns a function that flushes it
+func startLogging(dataDir string) (func(), error) {
+	file := &rotatingFile{path: filepath.Join(dataDir, logFilename), maxBytes: logMaxBytes, backups: logBackups}
+	if err := file.open(); err != nil {
+		return func() {}, &storeError{Store: "log", Op: "open", Key: file.path, Err: err}
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), file, logLevel), zap.AddCaller())
+	zap.ReplaceGlobals(logger)
+	zap.RedirectStdLog(logger)
+
+	return func() { _ = logger.Sync() }, nil
+}
+
+// The levels logLevel can be set to. Zap's own panic and fatal levels are left out, nothing
+// logs at them
+var logLevels = map[string]zapcore.Level{
+	"debug": zap.DebugLevel,
+	"info":  zap.InfoLevel,
+	"warn":  zap.WarnLevel,
+	"error": zap.ErrorLevel,
+}
+
+// Sets the level logged at, anything that isn't a level (already reported by validate) is
+// left at the default
+func setLogLevel(level string) {
+	parsed, ok := logLevels[level]
+	if !ok {
+		parsed = zap.InfoLevel
+	}
+	logLevel.SetLevel(parsed)
+}
+
+// Checks the log level is one of ours
+func validateLogLevel(level string) error {
+	if _, ok := logLevels[level]; !ok {
+		return fmt.Errorf("logLevel must be debug, info, warn or error, got %q", level)
+	}
+
+	return nil
+}
+
+// Logs a finished storage operation, at debug level unless it failed
+func logStoreOp(store, op, key string, started time.Time, err error, fields ...zap.Field) {
+	fields = append(fields, zap.String("store", store), zap.String("op", op), zap.String("key", key),
+		zap.Duration("took", time.Since(started)))
+	if err != nil {
+		zap.L().Warn("storage operation failed", append(fields, zap.Error(err))...)
+		return
+	}
+	zap.L().Debug("storage operation", fields...)
+}
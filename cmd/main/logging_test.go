@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), logFilename)
+	file := &rotatingFile{path: path, maxBytes: 20, backups: 2}
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("ERROR: Write failed: %v", err)
+		}
+	}
+	file.file.Close()
+
+	// every line went over the size, so each is in its own file and the oldest was dropped
+	for suffix, expected := range map[string]string{"": "fourth line\n", ".1": "third line\n", ".2": "second line\n"} {
+		contents, err := os.ReadFile(path + suffix)
+		if err != nil || string(contents) != expected {
+			t.Errorf("ERROR: Expected: %q in %s got: %q, %v", expected, logFilename+suffix, contents, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("ERROR: Expected: no third backup got: %v", err)
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	defer setLogLevel(defaultLogLevel)
+	tests := []struct {
+		level    string
+		expected zapcore.Level
+		valid    bool
+	}{
+		{"debug", zap.DebugLevel, true},
+		{"warn", zap.WarnLevel, true},
+		{"verbose", zap.InfoLevel, false},
+		{"fatal", zap.InfoLevel, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.level, func(t *testing.T) {
+			setLogLevel(test.level)
+			if logLevel.Level() != test.expected {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, logLevel.Level())
+			}
+			if err := validateLogLevel(test.level); (err == nil) != test.valid {
+				t.Errorf("ERROR: Expected: valid %v got: %v", test.valid, err)
+			}
+		})
+	}
+}
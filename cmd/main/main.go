@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/lxn/walk"
 	. "github.com/lxn/walk/declarative"
+	"go.uber.org/zap"
 	"golang.org/x/sys/windows/registry"
 )
 
@@ -17,6 +21,15 @@ const (
 	regValue4        = "monthOfYear"
 	regValue5        = "bwMin"
 	regValue6        = "bwMax"
+	regValue7        = "history"
+	regValue8        = "devices"
+	regValue9        = "bwUploadUsed"
+	regValue10       = "usedByDay"   // running total at the end of each day
+	regValue11       = "uploadByDay" // running upload total at the end of each day
+	regValue12       = "unmetered"
+	regValue13       = "banked"
+	regValue14       = "enforced" // cycle router shaping was applied in
+	regValue15       = "audit"
 	initialWinWidth  = 850
 	initialWinHeight = 1000
 	graphImgHeight   = 750
@@ -26,29 +39,74 @@ type MainWin struct {
 	*walk.MainWindow
 	resultMsgBox, barGraphBox             *walk.TextEdit
 	bwTextBox, lowerTextBox, upperTextBox *walk.LineEdit
+	upTextBox                             *walk.LineEdit // the upload part of what's in bwTextBox
 	fillPrevDaysCheckBox                  *walk.CheckBox
+	statusLabel                           *walk.Label
+	profileBox, chartModeBox              *walk.ComboBox
+	summaryButton                         *walk.PushButton
 	graphImage                            *walk.ImageView
 	key                                   *registry.Key
-	useEtcd                               bool
+	etcd                                  *etcdStore
+	stopWatching                          func()
+	useEtcd, degraded, enforcing          bool
+	chartMode                             int // one of the chart consts in graph.go
+	advice                                budgetAdvice
+	dayEdits                              []string // audit ids of the day editor's edits, newest last
+	retryTicker                           *time.Ticker
+	health                                []endpointHealth
 	config                                Config
-	exePath                               string
+	profile                               string            // name of the profile in use, if there are any
+	configPath                            string            // "" when there is no config.yml
+	overrides                             map[string]string // from the command line and environment
+	dataDir                               string            // where the journal is kept
 }
 
 func main() {
 	var appIcon, _ = walk.NewIconFromResourceId(2) // number 2 is resource ID printed by rsrc.exe when using v0.10+
 	mw := new(MainWin)
 
-	// first get values from conf
-	mw.exePath, _ = os.Getwd()
-	if mw.exePath[len(mw.exePath)-4:] == "\\cmd" || mw.exePath[len(mw.exePath)-4:] == "\\bin" {
-		mw.exePath = mw.exePath[:len(mw.exePath)-4]
+	// first work out where the config is, and what the command line and environment override
+	var optionErrors bytes.Buffer
+	opts, err := parseOptions(os.Args[1:], os.Getenv, &optionErrors)
+	if err != nil {
+		attachConsole() // only now, so a gui started with --config keeps off the parent's console
+		os.Stderr.Write(optionErrors.Bytes())
+		os.Exit(2)
+	}
+	mw.overrides = opts.overrides
+	mw.configPath, err = findConfigFile(opts.configPath)
+	if err != nil && len(opts.args) > 0 {
+		attachConsole() // a command must not carry on with the defaults when the config named is missing
+		fmt.Fprintf(os.Stderr, "Could not find config: %v\n", err)
+		os.Exit(1)
+	}
+	mw.dataDir = dataDirFor(mw.configPath)
+	flushLog, logErr := startLogging(mw.dataDir)
+	defer flushLog()
+	if handled, exitCode := runCommand(opts.args, mw.configPath, mw.overrides); handled {
+		flushLog()
+		os.Exit(exitCode)
+	}
+	zap.L().Info("starting", zap.String("config", mw.configPath), zap.String("dataDir", mw.dataDir))
+	if logErr != nil {
+		mw.showError("Not logging to a file", logErr)
+	}
+	if err != nil {
+		mw.showError("Could not find config", err)
+	} else if err := mw.getConfigAndDBValues(); err != nil {
+		mw.showError("Could not load values", err)
+	}
+
+	initialResults, _ := mw.calculateBandwidth()
+	uploadText := ""
+	if mw.config.bwUploadUsed != 0 {
+		uploadText = strconv.FormatFloat(mw.config.bwUploadUsed, 'f', -1, 64)
 	}
-	mw.getConfigAndDBValues(mw.exePath + "\\config.yml")
 
 	MainWindow{
 		AssignTo: &mw.MainWindow,
 		Icon:     appIcon,
-		Title:    "Bandwidth Calculator",
+		Title:    appTitle,
 		Size:     Size{initialWinWidth, initialWinHeight},
 		MinSize:  Size{initialWinWidth, initialWinHeight},
 		Layout:   VBox{},
@@ -69,18 +127,53 @@ func main() {
 								// OnKeyPress event fires before we get the number, need to use OnKeyUp
 								OnKeyUp: func(keystroke walk.Key) {
 									if keystroke >= walk.Key0 && keystroke <= walk.Key9 { // if a digit key pressed
-										mw.resultMsgBox.SetText(mw.calculateBandwidth())
+										mw.showResults()
+									}
+								},
+							},
+							Label{
+								Text: "Of which upload:",
+							},
+							LineEdit{
+								AssignTo:    &mw.upTextBox,
+								Text:        uploadText,
+								ToolTipText: "Optional, the upload part of the bandwidth used",
+								OnKeyUp: func(keystroke walk.Key) {
+									if keystroke >= walk.Key0 && keystroke <= walk.Key9 {
+										mw.showResults()
 									}
 								},
 							},
+							Label{
+								AssignTo: &mw.statusLabel,
+							},
+							ComboBox{
+								AssignTo: &mw.profileBox,
+								Visible:  len(mw.config.Profiles) > 0,
+								OnCurrentIndexChanged: func() {
+									mw.switchProfile(mw.profileBox.Text())
+								},
+							},
+							PushButton{
+								AssignTo: &mw.summaryButton,
+								Text:     "   All profiles   ",
+								Visible:  len(mw.config.Profiles) > 0,
+								OnClicked: func() {
+									mw.showProfileSummary()
+								},
+							},
 							PushButton{
 								Text: "        Press to calculate        ",
 								OnClicked: func() {
 									// write values to db, reload them and update gui
-									mw.resultMsgBox.SetText(mw.calculateBandwidth())
-									mw.writeValuesToDB()
-									mw.makeChart()
-									mw.refreshImage()
+									if err := mw.showResults(); err != nil {
+										return // rejected readings are never saved or enforced
+									}
+									if err := mw.writeValuesToDB(); err != nil {
+										mw.showError("Values not saved", err)
+									}
+									mw.enforceBudgetInBackground()
+									mw.redrawChart()
 								},
 							},
 						},
@@ -97,7 +190,7 @@ func main() {
 							Family:    "Ariel",
 							PointSize: 17,
 						},
-						Text: mw.calculateBandwidth(),
+						Text: initialResults,
 						OnBoundsChanged: func() {
 							mw.resultMsgBox.SetWidth(mw.Width() - 35)
 						},
@@ -134,13 +227,54 @@ func main() {
 								AssignTo: &mw.fillPrevDaysCheckBox,
 								Checked:  true,
 							},
+							Label{
+								Text: "Chart:",
+							},
+							ComboBox{
+								Model:        chartModes,
+								CurrentIndex: chartDayLeft,
+								OnCurrentIndexChanged: func() {
+									if mw.chartMode != mw.chartModeBox.CurrentIndex() {
+										mw.chartMode = mw.chartModeBox.CurrentIndex()
+										mw.redrawChart()
+									}
+								},
+								AssignTo: &mw.chartModeBox,
+							},
 							PushButton{
-								Text: "   Delete latest day data   ",
+								Text: "   Device usage...   ",
 								OnClicked: func() {
-									// write values to db, reload them and update gui
-									mw.deleteLastDaysData()
-									mw.makeChart()
-									mw.refreshImage()
+									mw.showDeviceDialog()
+								},
+							},
+							PushButton{
+								Text: "   Compare months   ",
+								OnClicked: func() {
+									mw.showCompareDialog()
+								},
+							},
+							PushButton{
+								Text: "   Export...   ",
+								OnClicked: func() {
+									mw.showExportDialog()
+								},
+							},
+							PushButton{
+								Text: "   Import...   ",
+								OnClicked: func() {
+									mw.showImportDialog()
+								},
+							},
+							PushButton{
+								Text: "   Audit trail...   ",
+								OnClicked: func() {
+									mw.showAuditDialog()
+								},
+							},
+							PushButton{
+								Text: "   Edit days...   ",
+								OnClicked: func() {
+									mw.showDayEditor()
 								},
 							},
 						},
@@ -158,9 +292,25 @@ func main() {
 	}.Create()
 
 	// make the bar graph
-	mw.makeChart()
-	mw.refreshImage()
+	mw.updateProfileBox()
+	mw.updateStatus()
+	mw.redrawChart()
+	mw.startWatching()
+	stopConfigWatch := mw.watchConfig()
 	mw.Run()
 
-	mw.writeValuesToDB()
+	stopConfigWatch()
+
+	if mw.stopWatching != nil {
+		mw.stopWatching()
+	}
+
+	if err := mw.writeValuesToDB(); err != nil {
+		mw.showError("Values not saved on exit", err)
+	}
+	if mw.retryTicker != nil {
+		mw.retryTicker.Stop()
+	}
+	mw.etcd.close()
+	zap.L().Info("exiting")
 }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/lxn/walk/declarative"
+)
+
+// One connection being tracked (home cable, cabin LTE and so on), each with its own cap,
+// billing cycle and place in etcd
+type Profile struct {
+	Name          string          `yaml:"name"`
+	Key           string          `yaml:"key"` // relative to etcd.baseKeyToWrite, or absolute if it starts with /
+	Cap           float64         `yaml:"cap"`
+	CycleStartDay int             `yaml:"cycleStartDay"`
+	CapCounts     string          `yaml:"capCounts"`
+	Unmetered     UnmeteredConfig `yaml:"unmetered"`
+	Pricing       Pricing         `yaml:"pricing"`
+	Rollover      RolloverPolicy  `yaml:"rollover"`
+}
+
+// Usage of one profile for its current cycle, as shown in the combined summary
+type profileUsage struct {
+	name       string
+	used, cap  float64
+	start, end time.Time
+}
+
+// Turns a name into something usable as a key, "Cabin LTE" becomes "cabin-lte"
+func keySlug(name string) string {
+	slug := strings.Builder{}
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			slug.WriteRune(r)
+		case slug.Len() > 0 && !strings.HasSuffix(slug.String(), "-"):
+			slug.WriteRune('-')
+		}
+	}
+
+	return strings.TrimSuffix(slug.String(), "-")
+}
+
+// Fills in each profile's cap and cycle from the top level settings, and makes every key absolute
+func (cfg *Config) applyProfileDefaults() {
+	for i := range cfg.Profiles {
+		profile := &cfg.Profiles[i]
+		profile.Name = strings.TrimSpace(profile.Name)
+		if profile.Cap == 0 {
+			profile.Cap = cfg.Cap
+		}
+		if profile.CycleStartDay == 0 {
+			profile.CycleStartDay = cfg.CycleStartDay
+		}
+		profile.CapCounts = strings.ToLower(strings.TrimSpace(profile.CapCounts))
+		if profile.CapCounts == "" {
+			profile.CapCounts = cfg.CapCounts
+		}
+		if profile.Unmetered.isEmpty() {
+			profile.Unmetered = cfg.Unmetered
+		}
+		if profile.Pricing == (Pricing{}) {
+			profile.Pricing = cfg.Pricing
+		} else if profile.Pricing.BlockSize == 0 {
+			profile.Pricing.BlockSize = defaultBlockSizeGB
+		}
+		if profile.Rollover == (RolloverPolicy{}) {
+			profile.Rollover = cfg.Rollover
+		}
+		profile.Key = strings.TrimRight(strings.TrimSpace(profile.Key), "/")
+		if profile.Key == "" {
+			profile.Key = keySlug(profile.Name)
+		}
+		if !strings.HasPrefix(profile.Key, "/") {
+			profile.Key = cfg.Etcd.BaseKeyToWrite + "/" + profile.Key
+		}
+	}
+	// there is only one router, so it follows one profile's budget
+	if cfg.Enforce.Router != "" && cfg.Enforce.Profile == "" && len(cfg.Profiles) > 0 {
+		cfg.Enforce.Profile = cfg.Profile
+		if cfg.Enforce.Profile == "" {
+			cfg.Enforce.Profile = cfg.Profiles[0].Name
+		}
+	}
+}
+
+// Checks the profiles (after defaults are applied), one error per problem
+func (cfg Config) validateProfiles() []error {
+	errs := []error{}
+	names := map[string]bool{}
+	keys := map[string]bool{}
+
+	for i, profile := range cfg.Profiles {
+		if keySlug(profile.Name) == "" {
+			errs = append(errs, fmt.Errorf("profiles[%d] needs a name", i))
+			continue
+		}
+		if names[keySlug(profile.Name)] {
+			errs = append(errs, fmt.Errorf("profiles has more than one profile named %q", profile.Name))
+		}
+		names[keySlug(profile.Name)] = true
+		if keys[profile.Key] {
+			errs = append(errs, fmt.Errorf("profile %q uses the same key as another profile, %s", profile.Name, profile.Key))
+		}
+		keys[profile.Key] = true
+		if profile.Cap < 0 {
+			errs = append(errs, fmt.Errorf("profile %q cap must be a positive number of GB, got %v", profile.Name, profile.Cap))
+		}
+		if profile.CycleStartDay < 1 || profile.CycleStartDay > maxCycleStartDay {
+			errs = append(errs, fmt.Errorf("profile %q cycleStartDay must be between 1 and %d, got %d", profile.Name, maxCycleStartDay, profile.CycleStartDay))
+		}
+		if profile.CapCounts != capCountsBoth && profile.CapCounts != capCountsDownload {
+			errs = append(errs, fmt.Errorf("profile %q capCounts must be %s or %s, got %q", profile.Name, capCountsBoth, capCountsDownload, profile.CapCounts))
+		}
+		errs = append(errs, profile.Unmetered.validate(fmt.Sprintf("profile %q unmetered", profile.Name))...)
+		errs = append(errs, profile.Pricing.validate(fmt.Sprintf("profile %q pricing", profile.Name))...)
+		errs = append(errs, profile.Rollover.validate(fmt.Sprintf("profile %q rollover", profile.Name))...)
+	}
+	if _, ok := cfg.findProfile(cfg.Profile); cfg.Profile != "" && !ok {
+		errs = append(errs, fmt.Errorf("profile %q is not one of the profiles listed", cfg.Profile))
+	}
+	if _, ok := cfg.findProfile(cfg.Enforce.Profile); cfg.Enforce.Profile != "" && !ok {
+		errs = append(errs, fmt.Errorf("enforce.profile %q is not one of the profiles listed", cfg.Enforce.Profile))
+	}
+
+	return errs
+}
+
+func (cfg Config) findProfile(name string) (Profile, bool) {
+	for _, profile := range cfg.Profiles {
+		if keySlug(profile.Name) == keySlug(name) {
+			return profile, true
+		}
+	}
+
+	return Profile{}, false
+}
+
+func (cfg Config) profileNames() []string {
+	names := []string{}
+	for _, profile := range cfg.Profiles {
+		names = append(names, profile.Name)
+	}
+
+	return names
+}
+
+// Makes the named profile's cap, cycle and key the ones in use, falling back to the default
+// profile (the profile setting, or else the first one) if name is empty. Returns the name of
+// the profile in use, which is empty when no profiles are configured
+func (cfg *Config) useProfile(name string) (string, error) {
+	cfg.profileID = ""
+	if len(cfg.Profiles) == 0 {
+		return "", nil
+	}
+	if name == "" {
+		name = cfg.Profile
+	}
+	if name == "" {
+		name = cfg.Profiles[0].Name
+	}
+	profile, ok := cfg.findProfile(name)
+	if !ok {
+		return "", fmt.Errorf("no profile named %q", name)
+	}
+	cfg.Cap = profile.Cap
+	cfg.CycleStartDay = profile.CycleStartDay
+	cfg.CapCounts = profile.CapCounts
+	cfg.Unmetered = profile.Unmetered
+	cfg.Pricing = profile.Pricing
+	cfg.Rollover = profile.Rollover
+	cfg.Etcd.BaseKeyToWrite = profile.Key
+	cfg.profileID = keySlug(profile.Name)
+
+	return profile.Name, nil
+}
+
+// Returns the registry value name to use for the profile in use, the registry has no key
+// prefixes so each profile's values get its name in front
+func (mw *MainWin) regName(regValue string) string {
+	if mw.config.profileID == "" {
+		return regValue
+	}
+
+	return mw.config.profileID + "/" + regValue
+}
+
+// Reads how much a profile has used so far this cycle, counting only what its cap counts and
+// leaving out unmetered usage. A reading from a previous cycle (not rolled over yet) counts as
+// nothing used
+func (mw *MainWin) readProfileUsed(profile Profile, now time.Time) (float64, error) {
+	if keySlug(profile.Name) == mw.config.profileID {
+		counted := countedUsage(mw.config.bwCurrentUsed, mw.config.bwUploadUsed, profile.CapCounts)
+		return meteredUsage(counted, unmeteredTotal(mw.config.dbValues, profile.Key)), nil
+	}
+
+	readings := map[string]string{}
+	unmetered := 0.0
+	if mw.etcd != nil {
+		for _, regValue := range []string{regValue1, regValue4, regValue9} {
+			values, _, err := mw.etcd.read(profile.Key + "/" + regValue)
+			if err != nil {
+				return 0, &storeError{Store: "etcd", Op: "read", Key: profile.Key, Err: err}
+			}
+			readings[regValue] = string(values[profile.Key+"/"+regValue])
+		}
+		if readings[regValue4] != strconv.Itoa(int(cycleStart(now, profile.CycleStartDay).Month())) {
+			return 0, nil
+		}
+		values, _, err := mw.etcd.read(profile.Key + "/" + regValue12 + "/")
+		if err != nil {
+			return 0, &storeError{Store: "etcd", Op: "read", Key: profile.Key, Err: err}
+		}
+		unmetered = unmeteredTotal(values, profile.Key)
+	} else {
+		for _, regValue := range []string{regValue1, regValue9} {
+			value, err := mw.GetRegStringValue(keySlug(profile.Name) + "/" + regValue)
+			if err != nil {
+				return 0, err
+			}
+			readings[regValue] = value
+		}
+	}
+	used, _ := strconv.ParseFloat(readings[regValue1], 64)
+	upload, _ := strconv.ParseFloat(readings[regValue9], 64)
+
+	return meteredUsage(countedUsage(used, upload, profile.CapCounts), unmetered), nil
+}
+
+// Works out where each profile is in its current cycle
+func summarizeProfiles(profiles []Profile, used map[string]float64, now time.Time) []profileUsage {
+	usages := []profileUsage{}
+	for _, profile := range profiles {
+		start := cycleStart(now, profile.CycleStartDay)
+		usages = append(usages, profileUsage{
+			name:  profile.Name,
+			used:  used[profile.Name],
+			cap:   profile.Cap,
+			start: start,
+			end:   start.AddDate(0, 1, 0),
+		})
+	}
+
+	return usages
+}
+
+// Builds the text table of every profile's usage, with a combined total at the bottom
+func formatProfileSummary(usages []profileUsage, now time.Time) string {
+	output := fmt.Sprintf("%-16s %10s %10s %10s %10s %12s\r\n", "Profile", "Used (GB)", "Cap (GB)", "Left (GB)", "Days left", "GB/day left")
+	totalUsed, totalCap := 0.0, 0.0
+	for _, usage := range usages {
+		left := usage.cap - usage.used
+		daysLeft := usage.end.Sub(now).Hours() / 24
+		output += fmt.Sprintf("%-16s %10.0f %10.0f %10.0f %10.1f %12.2f\r\n",
+			usage.name, usage.used, usage.cap, left, daysLeft, lower(left/daysLeft, left))
+		totalUsed += usage.used
+		totalCap += usage.cap
+	}
+	output += fmt.Sprintf("%-16s %10.0f %10.0f %10.0f\r\n", "Combined", totalUsed, totalCap, totalCap-totalUsed)
+
+	return output
+}
+
+// Reads every profile's usage and builds the combined summary
+func (mw *MainWin) profileSummary() (string, error) {
+	if len(mw.config.Profiles) == 0 {
+		return "", errors.New("no profiles are configured in config.yml")
+	}
+	now := time.Now()
+	used := map[string]float64{}
+	for _, profile := range mw.config.Profiles {
+		value, err := mw.readProfileUsed(profile, now)
+		if err != nil {
+			return "", err
+		}
+		used[profile.Name] = value
+	}
+
+	return formatProfileSummary(summarizeProfiles(mw.config.Profiles, used, now), now), nil
+}
+
+// Connects to etcd, or opens the registry if there is no etcd, without any dialogs. For
+// command line use, where there is no window to show them in
+func (mw *MainWin) openStore() error {
+	if mw.etcdConfigured() {
+		endpoints, err := mw.checkEndpointHealth()
+		if len(endpoints) > 0 {
+			if mw.etcd, err = mw.connectEtcd(endpoints); err == nil {
+				mw.useEtcd = true
+				return nil
+			}
+		}
+		if err == nil {
+			err = errEtcdTimeout
+		}
+		return &storeError{Store: "etcd", Op: "connect", Err: err}
+	}
+	key, err := mw.getRegKeyValues()
+	if err != nil {
+		return err
+	}
+	mw.key = &key
+
+	return nil
+}
+
+// Reads the values of the profile in use from whichever store openStore opened, without
+// rolling over or syncing anything. For command line use
+func (mw *MainWin) readStoredValues() error {
+	if mw.useEtcd {
+		var err error
+		mw.config.dbValues, mw.config.dbRevisions, err = mw.etcd.read(mw.config.Etcd.BaseKeyToWrite + "/")
+		if err != nil {
+			return &storeError{Store: "etcd", Op: "read", Key: mw.config.Etcd.BaseKeyToWrite, Err: err}
+		}
+		mw.config.bwCurrentUsed, _ = strconv.ParseFloat(string(mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue1]), 64)
+		mw.config.bwUploadUsed, _ = strconv.ParseFloat(string(mw.config.dbValues[mw.config.Etcd.BaseKeyToWrite+"/"+regValue9]), 64)
+		return nil
+	}
+	used, err := mw.GetRegStringValue(mw.regName(regValue1))
+	if err != nil {
+		return err
+	}
+	mw.config.bwCurrentUsed, _ = strconv.ParseFloat(used, 64)
+	upload, err := mw.GetRegStringValue(mw.regName(regValue9))
+	if err != nil {
+		return err
+	}
+	mw.config.bwUploadUsed, _ = strconv.ParseFloat(upload, 64)
+
+	return nil
+}
+
+// Switches every value, the chart and the result panel over to another profile. Anything
+// typed but not calculated for the old profile is dropped
+func (mw *MainWin) switchProfile(name string) {
+	if name == "" || name == mw.profile {
+		return
+	}
+	mw.profile = name
+	mw.reload()
+	mw.showReadings()
+	mw.showResults()
+}
+
+// Shows the profile picker only when there are profiles, with the one in use selected
+func (mw *MainWin) updateProfileBox() {
+	if mw.profileBox == nil {
+		return
+	}
+	names := mw.config.profileNames()
+	mw.profileBox.SetModel(names)
+	for i, name := range names {
+		if name == mw.profile {
+			mw.profileBox.SetCurrentIndex(i)
+		}
+	}
+	mw.profileBox.SetVisible(len(names) > 0)
+	mw.summaryButton.SetVisible(len(names) > 0)
+}
+
+// Opens a window with every profile's usage side by side
+func (mw *MainWin) showProfileSummary() {
+	table, err := mw.profileSummary()
+	if err != nil {
+		mw.showError("Could not build summary", err)
+		return
+	}
+
+	Dialog{
+		Title:   "All profiles",
+		MinSize: Size{initialWinWidth - 150, 250},
+		Layout:  VBox{},
+		Children: []Widget{
+			TextEdit{
+				Text:     table,
+				ReadOnly: true,
+				Font: Font{
+					Family:    "Courier New",
+					PointSize: 10,
+				},
+			},
+		},
+	}.Run(mw)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	cfg := Config{Cap: 1229}
+	cfg.Etcd.BaseKeyToWrite = "/nate/CalcBandwidth"
+	cfg.Profiles = []Profile{
+		{Name: "Home cable"},
+		{Name: "Cabin LTE", Cap: 50, CycleStartDay: 15},
+		{Name: "Old data", Key: "/nate/CalcBandwidth/"},
+	}
+	cfg.applyDefaults()
+
+	expectedKeys := []string{"/nate/CalcBandwidth/home-cable", "/nate/CalcBandwidth/cabin-lte", "/nate/CalcBandwidth"}
+	for i, profile := range cfg.Profiles {
+		if profile.Key != expectedKeys[i] {
+			t.Errorf("ERROR: Expected: %s got: %s", expectedKeys[i], profile.Key)
+		}
+	}
+	if cfg.Profiles[0].Cap != 1229 || cfg.Profiles[0].CycleStartDay != 1 {
+		t.Errorf("ERROR: Expected: 1229, 1 got: %v, %d", cfg.Profiles[0].Cap, cfg.Profiles[0].CycleStartDay)
+	}
+	if errs := cfg.validate(); len(errs) != 0 {
+		t.Errorf("ERROR: Expected: no errors got: %v", errs)
+	}
+
+	name, err := cfg.useProfile("cabin lte")
+	if err != nil || name != "Cabin LTE" {
+		t.Fatalf("ERROR: Expected: Cabin LTE got: %q, %v", name, err)
+	}
+	if cfg.Cap != 50 || cfg.CycleStartDay != 15 || cfg.Etcd.BaseKeyToWrite != expectedKeys[1] || cfg.profileID != "cabin-lte" {
+		t.Errorf("ERROR: Expected: 50, 15, %s, cabin-lte got: %v, %d, %s, %s", expectedKeys[1], cfg.Cap, cfg.CycleStartDay, cfg.Etcd.BaseKeyToWrite, cfg.profileID)
+	}
+	if name, _ = cfg.useProfile(""); name != "Home cable" {
+		t.Errorf("ERROR: Expected: the first profile by default got: %q", name)
+	}
+	if _, err = cfg.useProfile("Mobile hotspot"); err == nil {
+		t.Errorf("ERROR: Expected: an error for a missing profile but got none")
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		profiles  []Profile
+		profile   string
+		expErrors int
+	}{
+		{"Check no profiles", nil, "", 0},
+		{"Check missing name", []Profile{{Name: " "}}, "", 1},
+		{"Check duplicate names", []Profile{{Name: "Cabin LTE"}, {Name: "cabin lte", Key: "other"}}, "", 1},
+		{"Check duplicate keys", []Profile{{Name: "Home"}, {Name: "Cabin", Key: "home"}}, "", 1},
+		{"Check cycle day too late", []Profile{{Name: "Home", CycleStartDay: 31}}, "", 1},
+		{"Check unknown default profile", []Profile{{Name: "Home"}}, "Cabin", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Config{Profiles: test.profiles, Profile: test.profile}
+			cfg.applyDefaults()
+			if errs := cfg.validateProfiles(); len(errs) != test.expErrors {
+				t.Errorf("ERROR: Expected: %d errors got: %v", test.expErrors, errs)
+			}
+		})
+	}
+}
+
+func TestFormatProfileSummary(t *testing.T) {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	profiles := []Profile{{Name: "Home", Cap: 1229, CycleStartDay: 1}, {Name: "Cabin", Cap: 50, CycleStartDay: 15}}
+	usages := summarizeProfiles(profiles, map[string]float64{"Home": 400, "Cabin": 20}, now)
+
+	if !usages[1].end.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ERROR: Expected: Cabin cycle to end 2024-03-15 got: %v", usages[1].end)
+	}
+	output := formatProfileSummary(usages, now)
+	lines := strings.Split(strings.TrimSpace(output), "\r\n")
+	if len(lines) != 4 {
+		t.Fatalf("ERROR: Expected: 4 lines got: %q", output)
+	}
+	expected := []string{"Cabin", "20", "50", "30", "5.0", "6.00"}
+	if fields := strings.Fields(lines[2]); strings.Join(fields, " ") != strings.Join(expected, " ") {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, fields)
+	}
+	if fields := strings.Fields(lines[3]); strings.Join(fields, " ") != "Combined 420 1279 859" {
+		t.Errorf("ERROR: Expected: Combined 420 1279 859 got: %v", fields)
+	}
+}
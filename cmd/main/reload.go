@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// How often config.yml is checked for changes
+const configPollInterval = 2 * time.Second
+
+// Returns true if the config file looks different to when we last checked, a file that has
+// appeared or gone away counts as a change too
+func configFileChanged(previous, current os.FileInfo) bool {
+	if previous == nil || current == nil {
+		return (previous == nil) != (current == nil)
+	}
+
+	return !previous.ModTime().Equal(current.ModTime()) || previous.Size() != current.Size()
+}
+
+// Polls config.yml and applies any change while the program is running. Returns a func that
+// stops watching
+func (mw *MainWin) watchConfig() (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		last, _ := os.Stat(mw.configPath)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if mw.configPath == "" {
+					continue
+				}
+				current, _ := os.Stat(mw.configPath)
+				if !configFileChanged(last, current) {
+					continue
+				}
+				last = current
+			}
+			mw.Synchronize(mw.reloadConfig)
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// Reloads the config and everything shown after config.yml changed
+func (mw *MainWin) reloadConfig() {
+	if mw.IsDisposed() {
+		return
+	}
+	mw.reload()
+	mw.updateProfileBox()
+	zap.L().Info("reloaded config", zap.String("config", mw.configPath), zap.String("profile", mw.profile))
+}
+
+// Reloads the config and everything shown. The etcd connection is only remade if the
+// connection settings changed, and the watch is moved if the base key did
+func (mw *MainWin) reload() {
+	previous := mw.config.Etcd
+	wasUsingEtcd := mw.useEtcd
+
+	mw.refreshFromDB()
+
+	if previous.BaseKeyToWrite != mw.config.Etcd.BaseKeyToWrite {
+		mw.dayEdits = nil // their ids are in the audit trail of the profile that was edited
+	}
+	if connectionSettingsChanged(previous, mw.config.Etcd) ||
+		previous.BaseKeyToWrite != mw.config.Etcd.BaseKeyToWrite || wasUsingEtcd != mw.useEtcd {
+		if mw.stopWatching != nil {
+			mw.stopWatching()
+			mw.stopWatching = nil
+		}
+		mw.startWatching()
+	}
+}
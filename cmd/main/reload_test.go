@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFileChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFilename)
+	if err := os.WriteFile(path, []byte("cap: 1229\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(path)
+	same, _ := os.Stat(path)
+	if configFileChanged(before, same) {
+		t.Errorf("ERROR: Expected: unchanged file got: changed")
+	}
+
+	if err := os.WriteFile(path, []byte("cap: 2000.5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if !configFileChanged(before, after) {
+		t.Errorf("ERROR: Expected: changed file got: unchanged")
+	}
+	if !configFileChanged(nil, after) || !configFileChanged(after, nil) || configFileChanged(nil, nil) {
+		t.Errorf("ERROR: Expected: appearing and disappearing files to count as changes")
+	}
+}
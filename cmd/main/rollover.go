@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Plans with rollover bank part of what a cycle didn't use for later cycles. The bank is kept
+// under the profile as banked/YYYY-MM, one lot per cycle it came from (by the month the cycle
+// started in), so each lot can expire on its own. Going over the base cap draws on the oldest
+// lots first
+
+// How unused data carries over into later cycles
+type RolloverPolicy struct {
+	Percent      float64 `yaml:"percent"`      // share of the unused cap banked, 0 for no rollover
+	MaxBanked    float64 `yaml:"maxBanked"`    // most GB that can be banked, 0 for no limit
+	ExpiryCycles int     `yaml:"expiryCycles"` // cycles banked data can be used for, 0 to never expire
+}
+
+func (p RolloverPolicy) isSet() bool {
+	return p.Percent > 0
+}
+
+// Checks the policy, where is the setting's name to use in the errors
+func (p RolloverPolicy) validate(where string) []error {
+	errs := []error{}
+	if p.Percent < 0 || p.Percent > 100 {
+		errs = append(errs, fmt.Errorf("%s.percent must be between 0 and 100, got %v", where, p.Percent))
+	}
+	if p.MaxBanked < 0 {
+		errs = append(errs, fmt.Errorf("%s.maxBanked can't be negative, got %v", where, p.MaxBanked))
+	}
+	if p.ExpiryCycles < 0 {
+		errs = append(errs, fmt.Errorf("%s.expiryCycles can't be negative, got %d", where, p.ExpiryCycles))
+	}
+
+	return errs
+}
+
+// Returns how many months the second YYYY-MM is after the first
+func monthsBetween(from, to string) int {
+	fromTime, err := time.Parse("2006-01", from)
+	if err != nil {
+		return 0
+	}
+	toTime, err := time.Parse("2006-01", to)
+	if err != nil {
+		return 0
+	}
+
+	return (toTime.Year()-fromTime.Year())*12 + int(toTime.Month()) - int(fromTime.Month())
+}
+
+// Returns the banked lots (cycle they came from to GB) under the base key that can still be
+// used in the cycle that started in month
+func getBankedLots(values map[string][]byte, baseKey, month string, policy RolloverPolicy) map[string]float64 {
+	lots := map[string]float64{}
+	bankedSubkey := baseKey + "/" + regValue13 + "/"
+	for k, v := range values {
+		if !strings.HasPrefix(k, bankedSubkey) {
+			continue
+		}
+		from := k[len(bankedSubkey):]
+		gb, err := strconv.ParseFloat(string(v), 64)
+		if err != nil || gb <= 0 || monthsBetween(from, month) < 1 {
+			continue
+		}
+		if policy.ExpiryCycles > 0 && monthsBetween(from, month) > policy.ExpiryCycles {
+			continue
+		}
+		lots[from] = gb
+	}
+
+	return lots
+}
+
+func bankedTotal(lots map[string]float64) float64 {
+	total := 0.0
+	for _, gb := range lots {
+		total += gb
+	}
+
+	return total
+}
+
+// Works out the bank for the cycle starting in next, from the lots usable in the cycle that
+// started in finished and what that cycle used. Overuse is drawn from the oldest lots, unused
+// cap is banked as a new lot, then expired lots are dropped and the newest trimmed to the
+// maximum
+func rollBank(lots map[string]float64, finished, next string, used, capGB float64, policy RolloverPolicy) map[string]float64 {
+	bank := map[string]float64{}
+	for from, gb := range lots {
+		bank[from] = gb
+	}
+	months := sortedKeys(bank) // YYYY-MM sorts oldest first
+
+	if over := used - capGB; over > 0 {
+		for _, from := range months {
+			drawn := lower(over, bank[from])
+			bank[from] -= drawn
+			over -= drawn
+			if bank[from] <= 0 {
+				delete(bank, from)
+			}
+		}
+	} else if policy.isSet() {
+		bank[finished] = -over * policy.Percent / 100
+	}
+
+	for from := range bank {
+		if policy.ExpiryCycles > 0 && monthsBetween(from, next) > policy.ExpiryCycles {
+			delete(bank, from)
+		}
+	}
+	if policy.MaxBanked > 0 {
+		excess := bankedTotal(bank) - policy.MaxBanked
+		months = sortedKeys(bank)
+		sort.Sort(sort.Reverse(sort.StringSlice(months)))
+		for _, from := range months {
+			if excess <= 0 {
+				break
+			}
+			trimmed := lower(excess, bank[from])
+			bank[from] -= trimmed
+			excess -= trimmed
+			if bank[from] <= 0 {
+				delete(bank, from)
+			}
+		}
+	}
+
+	return bank
+}
+
+// Returns the GB banked for the cycle in use
+func (mw *MainWin) bankedForCycle(start time.Time) float64 {
+	return bankedTotal(getBankedLots(mw.config.dbValues, mw.config.Etcd.BaseKeyToWrite, start.Format("2006-01"), mw.config.Rollover))
+}
+
+// Returns what to write and delete so the bank under the base key carries over from the
+// finished cycle to the one starting in next
+func (mw *MainWin) carryOver(finished, next string) (map[string]string, []string) {
+	baseKey := mw.config.Etcd.BaseKeyToWrite
+	used, _ := strconv.ParseFloat(string(mw.config.dbValues[baseKey+"/"+regValue1]), 64)
+	upload, _ := strconv.ParseFloat(string(mw.config.dbValues[baseKey+"/"+regValue9]), 64)
+	metered := meteredUsage(countedUsage(used, upload, mw.config.CapCounts), unmeteredTotal(mw.config.dbValues, baseKey))
+
+	lots := getBankedLots(mw.config.dbValues, baseKey, finished, mw.config.Rollover)
+	bank := rollBank(lots, finished, next, metered, mw.config.Cap, mw.config.Rollover)
+
+	puts := map[string]string{}
+	for from, gb := range bank {
+		puts[baseKey+"/"+regValue13+"/"+from] = fmt.Sprintf("%.3f", gb)
+	}
+	deletes := []string{}
+	bankedSubkey := baseKey + "/" + regValue13 + "/"
+	for k := range mw.config.dbValues {
+		if _, kept := puts[k]; strings.HasPrefix(k, bankedSubkey) && !kept {
+			deletes = append(deletes, k)
+		}
+	}
+	sort.Strings(deletes)
+
+	return puts, deletes
+}
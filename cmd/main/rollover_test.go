@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBankedLots(t *testing.T) {
+	values := map[string][]byte{
+		"/bw/banked/2023-10":         []byte("50.000"),
+		"/bw/banked/2024-01":         []byte("20.000"),
+		"/bw/banked/2024-02":         []byte("5.000"), // banked for later cycles only
+		"/bw/banked/2023-12":         []byte("0.000"),
+		"/bw/history/2024-01/banked": []byte("70.000"),
+	}
+
+	lots := getBankedLots(values, "/bw", "2024-02", RolloverPolicy{Percent: 50, ExpiryCycles: 3})
+	if expected := map[string]float64{"2024-01": 20}; !reflect.DeepEqual(lots, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, lots)
+	}
+	lots = getBankedLots(values, "/bw", "2024-02", RolloverPolicy{Percent: 50})
+	if expected := map[string]float64{"2023-10": 50, "2024-01": 20}; !reflect.DeepEqual(lots, expected) {
+		t.Errorf("ERROR: Expected: %v got: %v", expected, lots)
+	}
+	if total := bankedTotal(lots); total != 70 {
+		t.Errorf("ERROR: Expected: 70 got: %v", total)
+	}
+}
+
+func TestRollBank(t *testing.T) {
+	lots := map[string]float64{"2023-11": 30, "2024-01": 40}
+
+	tests := []struct {
+		name     string
+		used     float64
+		policy   RolloverPolicy
+		expected map[string]float64
+	}{
+		{"Check unused cap is banked", 800, RolloverPolicy{Percent: 50},
+			map[string]float64{"2023-11": 30, "2024-01": 40, "2024-02": 100}},
+		{"Check overuse draws on the oldest first", 1040, RolloverPolicy{Percent: 50},
+			map[string]float64{"2024-01": 30}},
+		{"Check overuse can empty the bank", 1200, RolloverPolicy{Percent: 50},
+			map[string]float64{}},
+		{"Check old lots expire", 1000, RolloverPolicy{Percent: 10, ExpiryCycles: 2},
+			map[string]float64{"2024-01": 40, "2024-02": 0}},
+		{"Check newest is trimmed to the maximum", 900, RolloverPolicy{Percent: 100, MaxBanked: 120},
+			map[string]float64{"2023-11": 30, "2024-01": 40, "2024-02": 50}},
+		{"Check no rollover keeps what's banked", 900, RolloverPolicy{},
+			map[string]float64{"2023-11": 30, "2024-01": 40}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bank := rollBank(lots, "2024-02", "2024-03", test.used, 1000, test.policy)
+			if !reflect.DeepEqual(bank, test.expected) {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, bank)
+			}
+		})
+	}
+	if lots["2023-11"] != 30 {
+		t.Errorf("ERROR: Expected: the lots passed in left alone got: %v", lots)
+	}
+}
+
+func TestValidateRollover(t *testing.T) {
+	if errs := (RolloverPolicy{Percent: 150, MaxBanked: -1, ExpiryCycles: -2}).validate("rollover"); len(errs) != 3 {
+		t.Errorf("ERROR: Expected: 3 errors got: %v", errs)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.etcd.io/etcd/pkg/transport"
+)
+
+// File names looked for inside CertPath when config.yml doesn't name them
+const (
+	etcdCAFile        = "ca.pem"
+	etcdCertFile      = "client.pem"
+	etcdKeyFile       = "client-key.pem"
+	defaultTLSVersion = "1.2"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Returns the full path of a cert file, relative names (and the default) are taken to be in CertPath
+func (cfg EtcdConfig) certFilePath(name, defaultName string) string {
+	if name == "" {
+		name = defaultName
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	return filepath.Join(cfg.CertPath, name)
+}
+
+// Returns the paths of the CA, client cert and client key files
+func (cfg EtcdConfig) tlsFiles() (string, string, string) {
+	return cfg.certFilePath(cfg.CAFile, etcdCAFile),
+		cfg.certFilePath(cfg.CertFile, etcdCertFile),
+		cfg.certFilePath(cfg.KeyFile, etcdKeyFile)
+}
+
+// Checks the TLS and auth settings, returning one error per problem worded so the user knows
+// which setting in config.yml to fix
+func (cfg EtcdConfig) validateTLS() []error {
+	errs := []error{}
+
+	if cfg.CertPath != "" {
+		if info, err := os.Stat(cfg.CertPath); err != nil {
+			errs = append(errs, fmt.Errorf("etcd.certpath %q can't be read: %v", cfg.CertPath, err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("etcd.certpath %q is not a directory", cfg.CertPath))
+		}
+	}
+	ca, cert, key := cfg.tlsFiles()
+	for _, file := range []struct{ setting, path string }{{"etcd.caFile", ca}, {"etcd.certFile", cert}, {"etcd.keyFile", key}} {
+		if info, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q can't be read: %v", file.setting, file.path, err))
+		} else if info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s %q is a directory, not a file", file.setting, file.path))
+		}
+	}
+	if cfg.MinTLSVersion != "" {
+		if _, ok := tlsVersions[cfg.MinTLSVersion]; !ok {
+			versions := []string{}
+			for version := range tlsVersions {
+				versions = append(versions, version)
+			}
+			sort.Strings(versions)
+			errs = append(errs, fmt.Errorf("etcd.minTLSVersion %q is not one of %s", cfg.MinTLSVersion, strings.Join(versions, ", ")))
+		}
+	}
+	if cfg.Username != "" && cfg.Password == "" {
+		errs = append(errs, errors.New("etcd.password must be set when etcd.username is"))
+	} else if cfg.Username == "" && cfg.Password != "" {
+		errs = append(errs, errors.New("etcd.username must be set when etcd.password is"))
+	}
+
+	return errs
+}
+
+// Builds the client TLS config from the configured files, server name and minimum version
+func (cfg EtcdConfig) tlsConfig() (*tls.Config, error) {
+	ca, cert, key := cfg.tlsFiles()
+	tlsInfo := transport.TLSInfo{
+		TrustedCAFile: ca,
+		CertFile:      cert,
+		KeyFile:       key,
+		ServerName:    cfg.ServerName,
+	}
+	tlsConfig, err := tlsInfo.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	minVersion := cfg.MinTLSVersion
+	if minVersion == "" {
+		minVersion = defaultTLSVersion
+	}
+	tlsConfig.MinVersion = tlsVersions[minVersion]
+
+	return tlsConfig, nil
+}
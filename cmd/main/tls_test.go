@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateTLS(t *testing.T) {
+	certPath := t.TempDir()
+	for _, name := range []string{etcdCAFile, etcdCertFile, etcdKeyFile, "other-ca.pem"} {
+		if err := os.WriteFile(filepath.Join(certPath, name), []byte("pem"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		cfg       EtcdConfig
+		expErrors int
+	}{
+		{"Check default file names", EtcdConfig{CertPath: certPath}, 0},
+		{"Check relative ca file", EtcdConfig{CertPath: certPath, CAFile: "other-ca.pem"}, 0},
+		{"Check missing ca file", EtcdConfig{CertPath: certPath, CAFile: "missing.pem"}, 1},
+		{"Check missing cert path", EtcdConfig{CertPath: filepath.Join(certPath, "missing")}, 4},
+		{"Check bad tls version", EtcdConfig{CertPath: certPath, MinTLSVersion: "1.4"}, 1},
+		{"Check username without password", EtcdConfig{CertPath: certPath, Username: "nate"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := test.cfg.validateTLS()
+			if len(errs) != test.expErrors {
+				t.Errorf("ERROR: Expected: %d errors got: %v", test.expErrors, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Usage that doesn't count against the cap (overnight windows, zero-rated services) is kept
+// under the profile as unmetered/DD, the GB per day taken off the reading before the cap sees it
+
+// Times and services a plan doesn't count against the cap
+type UnmeteredConfig struct {
+	Windows    []UnmeteredWindow `yaml:"windows"`
+	Categories []string          `yaml:"categories"` // zero-rated traffic, as tagged by whoever records it
+}
+
+// A time of day that isn't metered. When To is before From the window runs past midnight,
+// Days being the days it starts on
+type UnmeteredWindow struct {
+	Days []string `yaml:"days"` // mon to sun, every day if left out
+	From string   `yaml:"from"` // HH:MM
+	To   string   `yaml:"to"`
+}
+
+func (u UnmeteredConfig) isEmpty() bool {
+	return len(u.Windows) == 0 && len(u.Categories) == 0
+}
+
+// Returns the weekday a name like "mon" or "Monday" stands for
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if len(name) >= 3 && strings.HasPrefix(full, name) {
+			return day, true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns the minutes since midnight of an HH:MM time
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an HH:MM time", clock)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Checks the windows, where is the setting's name to use in the errors
+func (u UnmeteredConfig) validate(where string) []error {
+	errs := []error{}
+	for i, window := range u.Windows {
+		from, fromErr := parseClock(window.From)
+		if fromErr != nil {
+			errs = append(errs, fmt.Errorf("%s.windows[%d].from: %v", where, i, fromErr))
+		}
+		to, toErr := parseClock(window.To)
+		if toErr != nil {
+			errs = append(errs, fmt.Errorf("%s.windows[%d].to: %v", where, i, toErr))
+		}
+		if fromErr == nil && toErr == nil && from == to {
+			errs = append(errs, fmt.Errorf("%s.windows[%d] starts and ends at the same time", where, i))
+		}
+		for _, day := range window.Days {
+			if _, ok := parseWeekday(day); !ok {
+				errs = append(errs, fmt.Errorf("%s.windows[%d] has an unknown day %q", where, i, day))
+			}
+		}
+	}
+	for i, category := range u.Categories {
+		if keySlug(category) == "" {
+			errs = append(errs, fmt.Errorf("%s.categories[%d] is empty", where, i))
+		}
+	}
+
+	return errs
+}
+
+// Returns true if the window covers the time
+func (window UnmeteredWindow) covers(at time.Time) bool {
+	from, err := parseClock(window.From)
+	if err != nil {
+		return false
+	}
+	to, err := parseClock(window.To)
+	if err != nil {
+		return false
+	}
+	startsOn := func(day time.Weekday) bool {
+		if len(window.Days) == 0 {
+			return true
+		}
+		for _, name := range window.Days {
+			if weekday, ok := parseWeekday(name); ok && weekday == day {
+				return true
+			}
+		}
+		return false
+	}
+
+	minute := at.Hour()*60 + at.Minute()
+	if from < to {
+		return minute >= from && minute < to && startsOn(at.Weekday())
+	}
+	// runs past midnight, so the early hours belong to the window that started the day before
+	if minute >= from {
+		return startsOn(at.Weekday())
+	}
+
+	return minute < to && startsOn((at.Weekday()+6)%7)
+}
+
+// Returns true if usage at the time, tagged with the category (which may be empty), is not metered
+func (u UnmeteredConfig) isUnmetered(at time.Time, category string) bool {
+	if slug := keySlug(category); slug != "" {
+		for _, zeroRated := range u.Categories {
+			if keySlug(zeroRated) == slug {
+				return true
+			}
+		}
+	}
+	for _, window := range u.Windows {
+		if window.covers(at) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the GB recorded as unmetered so far this cycle
+func unmeteredTotal(values map[string][]byte, baseKey string) float64 {
+	total := 0.0
+	for _, gb := range getDaySeries(values, baseKey+"/"+regValue12) {
+		total += gb
+	}
+
+	return total
+}
+
+// Returns what counts against the cap once the unmetered usage is taken off
+func meteredUsage(counted, unmetered float64) float64 {
+	if unmetered > counted {
+		return 0
+	}
+
+	return counted - unmetered
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsUnmetered(t *testing.T) {
+	rules := UnmeteredConfig{
+		Windows: []UnmeteredWindow{
+			{From: "23:00", To: "06:00", Days: []string{"fri", "Saturday"}},
+			{From: "12:00", To: "13:00"},
+		},
+		Categories: []string{"Console updates"},
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		category string
+		expected bool
+	}{
+		{"Check friday night is unmetered", time.Date(2024, 3, 1, 23, 30, 0, 0, time.Local), "", true},
+		{"Check early saturday belongs to friday's window", time.Date(2024, 3, 2, 5, 59, 0, 0, time.Local), "", true},
+		{"Check early friday belongs to thursday's window", time.Date(2024, 3, 1, 2, 0, 0, 0, time.Local), "", false},
+		{"Check end of window is metered", time.Date(2024, 3, 2, 6, 0, 0, 0, time.Local), "", false},
+		{"Check every day window", time.Date(2024, 3, 4, 12, 15, 0, 0, time.Local), "", true},
+		{"Check zero-rated category", time.Date(2024, 3, 4, 18, 0, 0, 0, time.Local), "console-updates", true},
+		{"Check other category", time.Date(2024, 3, 4, 18, 0, 0, 0, time.Local), "streaming", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if unmetered := rules.isUnmetered(test.at, test.category); unmetered != test.expected {
+				t.Errorf("ERROR: Expected: %v got: %v", test.expected, unmetered)
+			}
+		})
+	}
+}
+
+func TestValidateUnmetered(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     UnmeteredConfig
+		expErrors int
+	}{
+		{"Check no rules", UnmeteredConfig{}, 0},
+		{"Check good window", UnmeteredConfig{Windows: []UnmeteredWindow{{From: "02:00", To: "08:00", Days: []string{"mon", "tue"}}}}, 0},
+		{"Check bad times", UnmeteredConfig{Windows: []UnmeteredWindow{{From: "2am", To: "25:00"}}}, 2},
+		{"Check empty window", UnmeteredConfig{Windows: []UnmeteredWindow{{From: "02:00", To: "02:00"}}}, 1},
+		{"Check unknown day", UnmeteredConfig{Windows: []UnmeteredWindow{{From: "02:00", To: "03:00", Days: []string{"mo"}}}}, 1},
+		{"Check empty category", UnmeteredConfig{Categories: []string{" "}}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errs := test.rules.validate("unmetered"); len(errs) != test.expErrors {
+				t.Errorf("ERROR: Expected: %d errors got: %v", test.expErrors, errs)
+			}
+		})
+	}
+}
+
+func TestMeteredUsage(t *testing.T) {
+	values := map[string][]byte{
+		"/bw/unmetered/01": []byte("12.5"),
+		"/bw/unmetered/02": []byte("7.5"),
+		"/bw/devices/tv/1": []byte("3"),
+	}
+	unmetered := unmeteredTotal(values, "/bw")
+	if unmetered != 20 {
+		t.Fatalf("ERROR: Expected: 20 got: %v", unmetered)
+	}
+	if metered := meteredUsage(500, unmetered); metered != 480 {
+		t.Errorf("ERROR: Expected: 480 got: %v", metered)
+	}
+	if metered := meteredUsage(10, unmetered); metered != 0 {
+		t.Errorf("ERROR: Expected: 0 got: %v", metered)
+	}
+}
@@ -12,7 +12,10 @@ require (
 	MyLibs v0.0.0-00010101000000-000000000000
 	github.com/lxn/walk v0.0.0-20210112085537-c389da54e794
 	github.com/wcharczuk/go-chart v2.0.1+incompatible
+	go.etcd.io/etcd v3.3.27+incompatible
+	go.uber.org/zap v1.27.0
 	golang.org/x/sys v0.25.0
+	google.golang.org/grpc v1.43.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -37,15 +40,12 @@ require (
 	github.com/prometheus/common v0.60.1 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	github.com/stretchr/testify v1.9.0 // indirect
-	go.etcd.io/etcd v3.3.27+incompatible // indirect
 	go.uber.org/multierr v1.10.0 // indirect
-	go.uber.org/zap v1.27.0 // indirect
 	golang.org/x/image v0.22.0 // indirect
 	golang.org/x/net v0.29.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
 	golang.org/x/time v0.8.0 // indirect
 	google.golang.org/genproto v0.0.0-20211208223120-3a66f561d7aa // indirect
-	google.golang.org/grpc v1.43.0 // indirect
 	google.golang.org/protobuf v1.35.2 // indirect
 	gopkg.in/Knetic/govaluate.v3 v3.0.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect